@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/jsonlog"
+	"github.com/lighten/internal/validator"
+)
+
+// listUsers maps to the "GET /v1/admin/users?<query_string>" endpoint.
+func (app *application) listUsers(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string
+		Email     string
+		Activated *bool
+		data.Filters
+	}
+
+	queryStr := r.URL.Query()
+
+	v := validator.New()
+
+	input.Name = app.readStr(queryStr, "name", "")
+	input.Email = app.readStr(queryStr, "email", "")
+	input.Activated = app.readOptionalBool(queryStr, "activated", v)
+
+	input.Page = app.readInt(queryStr, "page", 1, v)
+	input.PageSize = app.readInt(queryStr, "page_size", 20, v)
+
+	input.Sort = app.readStr(queryStr, "sort", "id")
+	input.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(r.Context(), input.Name, input.Email, input.Activated, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "users": users}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUser maps to the "GET /v1/admin/users/:id" endpoint.
+func (app *application) showUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "roles": roles, "permissions": permissionsOrEmpty(permissions)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUser maps to the "PATCH /v1/admin/users/:id" endpoint. Deactivating
+// a user also signs them out everywhere.
+func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		Email     *string `json:"email"`
+		Activated *bool   `json:"activated"`
+		Language  *string `json:"language"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	wasActivated := user.Activated
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+	if input.Email != nil {
+		user.Email = *input.Email
+	}
+	if input.Activated != nil {
+		user.Activated = *input.Activated
+	}
+	if input.Language != nil {
+		user.Language = strings.ToLower(*input.Language)
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if wasActivated && !user.Activated {
+		err = app.revokeUserSessions(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUser maps to the "DELETE /v1/admin/users/:id" endpoint.
+func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := app.retrieveIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Users.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Signed tokens outlive the deleted rows, so they are denylisted too.
+	err = app.revokeUserSessions(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantUserPermissions maps to the "PUT /v1/admin/users/:id/permissions" endpoint.
+func (app *application) grantUserPermissions(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, app.models.Permissions.AddForUser)
+}
+
+// revokeUserPermissions maps to the "DELETE /v1/admin/users/:id/permissions" endpoint.
+func (app *application) revokeUserPermissions(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, app.models.Permissions.RemoveForUser)
+}
+
+// changeUserPermissions validates the permission codes in the request body,
+// applies change to the addressed user and responds with their permissions.
+func (app *application) changeUserPermissions(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, userID int64, codes ...string) error) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	for _, code := range input.Permissions {
+		v.Check(known.Include(code), "permissions", "must only contain known permission codes")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = change(r.Context(), user.ID, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.expireUserClaims(user.ID)
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissionsOrEmpty(permissions)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listPermissions maps to the "GET /v1/admin/permissions" endpoint.
+func (app *application) listPermissions(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminTargetUser fetches the user addressed by the URL. It writes the
+// error response itself and reports false when the handler should stop.
+func (app *application) adminTargetUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.retrieveIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// permissionsOrEmpty makes a user without permissions encode as [] rather than null.
+func permissionsOrEmpty(permissions data.Permissions) data.Permissions {
+	if permissions == nil {
+		return data.Permissions{}
+	}
+	return permissions
+}
+
+// showLogLevel maps to the "GET /v1/admin/log-level" endpoint.
+func (app *application) showLogLevel(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": app.logger.Level()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevel maps to the "PUT /v1/admin/log-level" endpoint. The new
+// minimum level applies immediately and lasts until the process restarts.
+func (app *application) updateLogLevel(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level *string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	var level jsonlog.Level
+	if v.Check(input.Level != nil, "level", "must be provided"); v.Valid() {
+		level, err = jsonlog.ParseLevel(*input.Level)
+		v.Check(err == nil, "level", "must be one of debug, info, warn, error, fatal or off")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logger.Level()
+	app.logger.SetLevel(level)
+
+	app.logger.Warn("log level changed", append(logFields(r.Context()),
+		jsonlog.String("from", previous.String()),
+		jsonlog.String("to", level.String()),
+		jsonlog.Int("user_id", app.contextGetUser(r).ID),
+	)...)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": level}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/validator"
+)
+
+const (
+	// maxImportBytes and maxImportRows bound a single import request.
+	maxImportBytes = 32 << 20
+	maxImportRows  = 10_000
+	// maxImportErrors bounds the row errors listed in an import report;
+	// Failed still counts every row that was skipped.
+	maxImportErrors = 100
+	// exportPageSize is the number of movies fetched per query while
+	// exporting.
+	exportPageSize = 100
+)
+
+var errTooManyImportRows = fmt.Errorf("an import must not contain more than %d rows", maxImportRows)
+
+// csvColumns are the columns of an exported CSV file. An imported file
+// needs a header naming at least title, year, runtime and genres, in any
+// order; other columns are ignored, so an export can be imported again.
+var csvColumns = []string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count"}
+
+// importRow is one movie read from an import, or the problems found with it.
+type importRow struct {
+	line     int
+	movie    *data.Movie
+	problems map[string]string
+}
+
+// movieDecoder reads the movies of an import one row at a time. Next
+// returns io.EOF after the last row, and any other error when the rest of
+// the input can't be read.
+type movieDecoder interface {
+	Next() (importRow, error)
+}
+
+// csvMovieDecoder reads a CSV file with a header row. Genres are separated
+// by "|".
+type csvMovieDecoder struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVMovieDecoder(r io.Reader) (*csvMovieDecoder, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("CSV file is empty")
+		}
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header must include a %q column", name)
+		}
+	}
+
+	return &csvMovieDecoder{reader: reader, columns: columns}, nil
+}
+
+func (d *csvMovieDecoder) Next() (importRow, error) {
+	record, err := d.reader.Read()
+	if err != nil {
+		return importRow{}, err
+	}
+
+	line, _ := d.reader.FieldPos(0)
+	row := importRow{line: line, movie: &data.Movie{}, problems: make(map[string]string)}
+
+	field := func(name string) string {
+		if i := d.columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	row.movie.Title = field("title")
+
+	if value := field("year"); value != "" {
+		year, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			row.problems["year"] = "must be an integer"
+		}
+		row.movie.Year = int32(year)
+	}
+
+	if value := strings.TrimSuffix(field("runtime"), " mins"); value != "" {
+		runtime, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			row.problems["runtime"] = "must be an integer number of minutes"
+		}
+		row.movie.Runtime = data.Runtime(runtime)
+	}
+
+	if value := field("genres"); value != "" {
+		for _, genre := range strings.Split(value, "|") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				row.movie.Genres = append(row.movie.Genres, genre)
+			}
+		}
+	}
+
+	return row, nil
+}
+
+// ndjsonMovieDecoder reads one JSON object per line, in the same form as
+// the body of "POST /v1/movies". The "genre" key used by exports is
+// accepted in place of "genres".
+type ndjsonMovieDecoder struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONMovieDecoder(r io.Reader) *ndjsonMovieDecoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	return &ndjsonMovieDecoder{scanner: scanner}
+}
+
+func (d *ndjsonMovieDecoder) Next() (importRow, error) {
+	for d.scanner.Scan() {
+		d.line++
+
+		text := strings.TrimSpace(d.scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var input struct {
+			Title   string       `json:"title"`
+			Runtime data.Runtime `json:"runtime"`
+			Year    int32        `json:"year"`
+			Genres  []string     `json:"genres"`
+			Genre   []string     `json:"genre"`
+		}
+
+		row := importRow{line: d.line, movie: &data.Movie{}, problems: make(map[string]string)}
+
+		if err := json.Unmarshal([]byte(text), &input); err != nil {
+			var typeError *json.UnmarshalTypeError
+			switch {
+			case errors.Is(err, data.ErrInvalidRuntimeFormat):
+				row.problems["runtime"] = "must be a string like \"102 mins\""
+			case errors.As(err, &typeError) && typeError.Field != "":
+				row.problems[typeError.Field] = "has the wrong type"
+			default:
+				row.problems["json"] = "line contains badly-formed JSON"
+			}
+			return row, nil
+		}
+
+		if input.Genres == nil {
+			input.Genres = input.Genre
+		}
+
+		row.movie.Title, row.movie.Year, row.movie.Runtime, row.movie.Genres = input.Title, input.Year, input.Runtime, input.Genres
+
+		return row, nil
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return importRow{}, err
+	}
+
+	return importRow{}, io.EOF
+}
+
+// readFormat picks csv or ndjson from the "format" query string parameter,
+// falling back to the media type in the named header.
+func (app *application) readFormat(r *http.Request, header string, v *validator.Validator) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		v.Check(validator.In(format, "csv", "ndjson"), "format", "must be csv or ndjson")
+		return format
+	}
+
+	for _, value := range strings.Split(r.Header.Get(header), ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(value))
+		switch mediaType {
+		case "text/csv":
+			return "csv"
+		case "application/x-ndjson", "application/ndjson":
+			return "ndjson"
+		}
+	}
+
+	return ""
+}
+
+// importError lists the problems found with one row of an import.
+type importError struct {
+	Line   int               `json:"line"`
+	Errors map[string]string `json:"errors"`
+}
+
+// importReport summarizes an import. Valid counts the rows that passed
+// validation and Imported those actually inserted, which is none for a dry
+// run or an all-or-nothing import with invalid rows.
+type importReport struct {
+	Format   string        `json:"format"`
+	Mode     string        `json:"mode"`
+	DryRun   bool          `json:"dry_run"`
+	Rows     int           `json:"rows"`
+	Valid    int           `json:"valid"`
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Errors   []importError `json:"errors,omitempty"`
+}
+
+func (report *importReport) fail(row importRow) {
+	report.Failed++
+	if len(report.Errors) < maxImportErrors {
+		report.Errors = append(report.Errors, importError{Line: row.line, Errors: row.problems})
+	}
+}
+
+// importMovies maps to the "POST /v1/movies/import?<query_string>"
+// endpoint, which is routed as "POST /v1/movies/:id". The body is a CSV or
+// NDJSON stream of movies, validated like the body of "POST /v1/movies". In
+// the default "atomic" mode the movies are only inserted if every row is
+// valid, in a single transaction; in "best_effort" mode each valid row is
+// inserted as it is read, and rows that are invalid or fail to insert are
+// skipped; should the input turn out unreadable or too large part way, the
+// error comes with the report of the rows read until then. With
+// dry_run=true rows are only validated.
+func (app *application) importMovies(w http.ResponseWriter, r *http.Request) {
+	if !app.isIDSegment(r, "import") {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	app.contextGetRequestInfo(r).pattern = "/v1/movies/import"
+
+	queryStr := r.URL.Query()
+
+	v := validator.New()
+
+	report := importReport{
+		Format: app.readFormat(r, "Content-Type", v),
+		Mode:   app.readStr(queryStr, "mode", "atomic"),
+	}
+	if dryRun := app.readOptionalBool(queryStr, "dry_run", v); dryRun != nil {
+		report.DryRun = *dryRun
+	}
+
+	v.Check(report.Format != "", "format", "must be given as csv or ndjson, or through the Content-Type header")
+	v.Check(validator.In(report.Mode, "atomic", "best_effort"), "mode", "must be atomic or best_effort")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var decoder movieDecoder
+
+	if report.Format == "csv" {
+		csvDecoder, err := newCSVMovieDecoder(body)
+		if err != nil {
+			app.importErrorResponse(w, r, err)
+			return
+		}
+		decoder = csvDecoder
+	} else {
+		decoder = newNDJSONMovieDecoder(body)
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	var valid []*data.Movie
+
+	for {
+		row, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil && report.Rows == maxImportRows {
+			err = errTooManyImportRows
+		}
+		if err != nil {
+			// Rows inserted by a best-effort import stay inserted, so
+			// report them along with the error.
+			if report.Mode == "best_effort" && !report.DryRun {
+				app.importAbortedResponse(w, r, err, report)
+			} else {
+				app.importErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		report.Rows++
+
+		if len(row.problems) == 0 {
+			v := validator.New()
+			if data.ValidateMovie(v, row.movie); !v.Valid() {
+				row.problems = v.Errors
+			}
+		}
+		if len(row.problems) > 0 {
+			report.fail(row)
+			continue
+		}
+
+		report.Valid++
+
+		switch {
+		case report.DryRun:
+		case report.Mode == "best_effort":
+			if err := app.models.Movies.Insert(r.Context(), row.movie, userID); err != nil {
+				if r.Context().Err() != nil {
+					return
+				}
+				// Like an invalid row, a row that fails to insert is
+				// reported and skipped.
+				app.logError(r, err)
+				row.problems = map[string]string{"row": "could not be inserted, please try again"}
+				report.fail(row)
+				continue
+			}
+			report.Imported++
+		default:
+			valid = append(valid, row.movie)
+		}
+	}
+
+	status := http.StatusOK
+
+	switch {
+	case report.Mode == "atomic" && report.Failed > 0:
+		status = http.StatusUnprocessableEntity
+	case len(valid) > 0:
+		if err := app.models.Movies.InsertMany(r.Context(), valid, userID); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		report.Imported = len(valid)
+	}
+
+	err := app.writeJSON(w, status, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importErrorResponse reports input that can't be read any further.
+func (app *application) importErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	status, msg := importErrorStatus(err)
+	app.errorResponse(w, r, status, msg)
+}
+
+// importAbortedResponse reports input that can't be read any further along
+// with the report of the rows read before, for a best-effort import that
+// has already inserted some of them.
+func (app *application) importAbortedResponse(w http.ResponseWriter, r *http.Request, err error, report importReport) {
+	status, msg := importErrorStatus(err)
+
+	env := envelope{"error": msg, "import": report}
+	if id := app.contextGetRequestInfo(r).id; id != "" {
+		env["request_id"] = id
+	}
+
+	err = app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importErrorStatus returns the status and message reporting an error
+// reading an import.
+func importErrorStatus(err error) (int, string) {
+	var (
+		maxBytesError *http.MaxBytesError
+		parseError    *csv.ParseError
+	)
+
+	switch {
+	case errors.Is(err, errTooManyImportRows):
+		return http.StatusRequestEntityTooLarge, err.Error()
+	case errors.As(err, &maxBytesError):
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit)
+	case errors.As(err, &parseError):
+		return http.StatusBadRequest, fmt.Sprintf("line %d: %s", parseError.Line, parseError.Err)
+	case errors.Is(err, bufio.ErrTooLong):
+		return http.StatusBadRequest, "body contains a line longer than 1MB"
+	default:
+		return http.StatusBadRequest, err.Error()
+	}
+}
+
+// exportMovies maps to the "GET /v1/movies/export?<query_string>" endpoint,
+// which showMovie dispatches to. It streams every movie matched by the
+// same filters and sort as listMovies, as CSV or NDJSON, fetching a page at
+// a time through keyset cursors so that neither the server nor the
+// database holds the whole catalogue at once. An export cut short by an
+// error ends with an X-Export-Error trailer and, for NDJSON, a final
+// {"error": ...} record, so that clients can tell it is incomplete.
+func (app *application) exportMovies(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title    string
+		Genres   []string
+		PersonID int64
+		Director string
+		data.Filters
+	}
+
+	queryStr := r.URL.Query()
+
+	v := validator.New()
+
+	format := app.readFormat(r, "Accept", v)
+	if format == "" {
+		format = "ndjson"
+	}
+
+	input.Title = app.readStr(queryStr, "title", "")
+	input.Genres = app.readCSV(queryStr, "genres", []string{})
+	input.PersonID = int64(app.readInt(queryStr, "person_id", 0, v))
+	input.Director = app.readStr(queryStr, "director", "")
+
+	input.Page = 1
+	input.PageSize = exportPageSize
+
+	input.Sort = app.readStr(queryStr, "sort", "id")
+	input.SortSafelist = []string{
+		"id", "title", "year", "runtime", "average_rating", "rating_count",
+		"-id", "-title", "-year", "-runtime", "-average_rating", "-rating_count",
+	}
+
+	input.CursorKey = []byte(app.config.pagination.cursorSecret)
+
+	v.Check(input.PersonID >= 0, "person_id", "must not be negative")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Fetch the first page before writing anything, so that a failing
+	// query can still be reported with an error response.
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.PersonID, input.Director, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var (
+		write func(movie *data.Movie) error
+		flush = func() {}
+		// fail marks the export as incomplete once the status is sent.
+		fail = func(msg string) {}
+	)
+
+	// A large export can take longer than the server's write timeout, which
+	// would cut the stream short, so lift the deadline for this response.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		app.logError(r, err)
+	}
+
+	w.Header().Set("Trailer", "X-Export-Error")
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+
+		csvWriter := csv.NewWriter(w)
+		flush = csvWriter.Flush
+
+		if err := csvWriter.Write(csvColumns); err != nil {
+			return
+		}
+
+		write = func(movie *data.Movie) error {
+			return csvWriter.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.FormatInt(int64(movie.Year), 10),
+				strconv.FormatInt(int64(movie.Runtime), 10),
+				strings.Join(movie.Genres, "|"),
+				strconv.FormatInt(int64(movie.Version), 10),
+				strconv.FormatFloat(movie.AverageRating, 'f', -1, 64),
+				strconv.FormatInt(int64(movie.RatingCount), 10),
+			})
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.ndjson"`)
+
+		encoder := json.NewEncoder(w)
+		write = func(movie *data.Movie) error {
+			return encoder.Encode(movie)
+		}
+		fail = func(msg string) {
+			_ = encoder.Encode(envelope{"error": msg})
+		}
+	}
+
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		for _, movie := range movies {
+			if err := write(movie); err != nil {
+				// The client has most likely gone away.
+				return
+			}
+		}
+		flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if metadata.NextCursor == "" {
+			return
+		}
+		input.Cursor = metadata.NextCursor
+
+		movies, metadata, err = app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.PersonID, input.Director, input.Filters)
+		if err != nil {
+			// The status has already been sent, so all that's left is to
+			// log the error and mark the export as cut short.
+			app.logError(r, err)
+
+			msg := "the export is incomplete, as the server encountered an error"
+			fail(msg)
+			w.Header().Set("X-Export-Error", msg)
+			return
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lighten/internal/flagconf"
+	"github.com/lighten/internal/jsonlog"
+	"github.com/lighten/internal/jwt"
+	"github.com/lighten/internal/validator"
+)
+
+// Holds configuration values
+type config struct {
+	port int
+	env  string
+	db   struct {
+		driver       string
+		dsn          string
+		dsnFile      string
+		maxOpenConns int
+		maxIdleConns int
+		maxIdleTime  string
+		queryTimeout string
+		autoMigrate  bool
+	}
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+	mail struct {
+		transport string
+		dir       string
+		apiURL    string
+		apiKey    string
+	}
+	smtp struct {
+		host         string
+		port         int
+		username     string
+		password     string
+		passwordFile string
+		sender       string
+		templatesDir string
+	}
+	cors struct {
+		trustedOrigins []string
+	}
+	pagination struct {
+		cursorSecret     string
+		cursorSecretFile string
+	}
+	log struct {
+		level           jsonlog.Level
+		stackTraceLevel jsonlog.Level
+		sampling        jsonlog.Sampling
+	}
+	tracing struct {
+		endpoint    string
+		serviceName string
+		sampleRatio float64
+	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	login struct {
+		freeAttempts     int
+		backoff          time.Duration
+		maxBackoff       time.Duration
+		lockoutThreshold int
+		lockoutDuration  time.Duration
+	}
+	jobs struct {
+		workers      int
+		pollInterval time.Duration
+		timeout      time.Duration
+		maxAttempts  int
+		backoff      time.Duration
+		maxBackoff   time.Duration
+	}
+	cache struct {
+		enabled bool
+		size    int
+		ttl     time.Duration
+	}
+	tokens struct {
+		authenticationTTL time.Duration
+		refreshTTL        time.Duration
+	}
+	auth struct {
+		mode        string
+		defaultRole string
+		jwt         struct {
+			keys         []jwt.Key
+			signingKeyID string
+			ttl          time.Duration
+		}
+	}
+
+	// These only steer startup and can't be set from the config file or
+	// the environment.
+	configFile     string
+	printConfig    bool
+	displayVersion bool
+}
+
+// listFlag is a flag.Value collecting whitespace-separated values, across
+// repeated uses of the flag or the elements of a config file array.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, strings.Fields(value)...)
+	return nil
+}
+
+// newConfigLoader defines a flag for every setting of cfg and returns the
+// loader that resolves them from the command line, the config file and
+// LIGHTEN_* environment variables.
+func newConfigLoader(cfg *config, errorHandling flag.ErrorHandling) *flagconf.Loader {
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+	if errorHandling == flag.ContinueOnError {
+		fs.SetOutput(io.Discard)
+	}
+
+	fs.IntVar(&cfg.port, "port", 4000, "Set port value")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+
+	fs.StringVar(&cfg.db.driver, "db-driver", "postgres", "Database driver (postgres|memory)")
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.StringVar(&cfg.db.dsnFile, "db-dsn-file", "", "File holding the PostgreSQL DSN, overriding db-dsn")
+
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle connections time")
+	fs.StringVar(&cfg.db.queryTimeout, "db-query-timeout", "3s", "Default timeout applied to each database query")
+	fs.BoolVar(&cfg.db.autoMigrate, "auto-migrate", false, "Apply pending migrations at startup")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+
+	fs.StringVar(&cfg.mail.transport, "mail-transport", "smtp", "How emails are delivered (smtp|file|http)")
+	fs.StringVar(&cfg.mail.dir, "mail-dir", "tmp/mail", "Directory the file mail transport writes .eml files to")
+	fs.StringVar(&cfg.mail.apiURL, "mail-api-url", "", "Endpoint the http mail transport posts emails to")
+	fs.StringVar(&cfg.mail.apiKey, "mail-api-key", "", "Bearer API key for the http mail transport")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
+	fs.StringVar(&cfg.smtp.host, "stmp-host", "smtp.mailtrap.io", "Deprecated spelling of smtp-host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.passwordFile, "smtp-password-file", "", "File holding the SMTP password, overriding smtp-password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Lighten API <no-reply@lighten.api.net>", "SMTP sender")
+	fs.StringVar(&cfg.smtp.templatesDir, "smtp-templates-dir", "", "Directory of *.tmpl mail templates overriding or adding to the built-in ones")
+
+	fs.Var((*listFlag)(&cfg.cors.trustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+
+	fs.DurationVar(&cfg.tokens.authenticationTTL, "token-authentication-ttl", 24*time.Hour, "Lifetime of authentication tokens")
+	fs.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour, "Lifetime of refresh tokens")
+
+	fs.StringVar(&cfg.auth.mode, "auth-mode", "token", "Authentication token type (token|jwt)")
+	fs.StringVar(&cfg.auth.defaultRole, "default-role", "viewer", "Role assigned to newly registered users (empty for none)")
+	fs.Func("jwt-key", "JWT key as kid:alg:base64 with alg HS256 or EdDSA (repeatable)", func(flagValue string) error {
+		key, err := jwt.ParseKey(flagValue)
+		if err != nil {
+			return err
+		}
+		cfg.auth.jwt.keys = append(cfg.auth.jwt.keys, key)
+
+		return nil
+	})
+	fs.StringVar(&cfg.auth.jwt.signingKeyID, "jwt-signing-kid", "", "ID of the JWT key used to sign new tokens")
+	fs.DurationVar(&cfg.auth.jwt.ttl, "jwt-ttl", 15*time.Minute, "Lifetime of JWT authentication tokens")
+
+	fs.TextVar(&cfg.log.level, "log-level", jsonlog.LevelInfo, "Minimum log level (debug|info|warn|error|fatal|off)")
+	fs.TextVar(&cfg.log.stackTraceLevel, "log-stack-trace-level", jsonlog.LevelError, "Minimum log level carrying a stack trace (off to disable)")
+	fs.DurationVar(&cfg.log.sampling.Tick, "log-sample-tick", 0, "Interval over which repeated log messages are sampled (disabled if 0)")
+	fs.IntVar(&cfg.log.sampling.First, "log-sample-first", 100, "Entries of each message logged per sampling interval before sampling starts")
+	fs.IntVar(&cfg.log.sampling.Thereafter, "log-sample-thereafter", 100, "Log every nth entry of a message once sampling starts (0 drops them all)")
+
+	fs.StringVar(&cfg.tracing.endpoint, "otlp-endpoint", "", "OTLP/HTTP collector base URL for trace export, e.g. http://localhost:4318 (disabled if empty)")
+	fs.StringVar(&cfg.tracing.serviceName, "trace-service-name", "lighten", "Service name reported with exported traces")
+	fs.Float64Var(&cfg.tracing.sampleRatio, "trace-sample-ratio", 1, "Fraction of new traces to sample (0-1)")
+
+	fs.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies stay restorable before being purged")
+	fs.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often movies past the trash retention are purged")
+
+	fs.IntVar(&cfg.login.freeAttempts, "login-free-attempts", 5, "Consecutive failed logins allowed before further attempts at an account are delayed")
+	fs.DurationVar(&cfg.login.backoff, "login-backoff", time.Second, "Delay after the first failed login past login-free-attempts, doubled with each further failure")
+	fs.DurationVar(&cfg.login.maxBackoff, "login-max-backoff", 5*time.Minute, "Longest delay between failed logins at an account")
+	fs.IntVar(&cfg.login.lockoutThreshold, "login-lockout-threshold", 10, "Consecutive failed logins that lock an account")
+	fs.DurationVar(&cfg.login.lockoutDuration, "login-lockout-duration", time.Hour, "How long a locked account stays locked unless unlocked through the emailed link")
+
+	fs.IntVar(&cfg.jobs.workers, "jobs-workers", 2, "Number of background jobs run at once")
+	fs.DurationVar(&cfg.jobs.pollInterval, "jobs-poll-interval", time.Second, "How often idle workers look for due background jobs")
+	fs.DurationVar(&cfg.jobs.timeout, "jobs-timeout", time.Minute, "Time limit for one attempt at a background job")
+	fs.IntVar(&cfg.jobs.maxAttempts, "jobs-max-attempts", 8, "Attempts at a background job before it is marked dead")
+	fs.DurationVar(&cfg.jobs.backoff, "jobs-backoff", 10*time.Second, "Delay before retrying a failed background job, doubled on each further retry")
+	fs.DurationVar(&cfg.jobs.maxBackoff, "jobs-max-backoff", time.Hour, "Longest delay between retries of a background job")
+
+	fs.BoolVar(&cfg.cache.enabled, "cache-enabled", true, "Cache token, user and permission lookups")
+	fs.IntVar(&cfg.cache.size, "cache-size", 10000, "Maximum number of cached lookups")
+	fs.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "Lifetime of cached lookups")
+
+	fs.StringVar(&cfg.pagination.cursorSecret, "cursor-secret", "", "Secret used to sign pagination cursors (random if empty)")
+	fs.StringVar(&cfg.pagination.cursorSecretFile, "cursor-secret-file", "", "File holding the pagination cursor secret, overriding cursor-secret")
+
+	fs.StringVar(&cfg.configFile, "config", "", "TOML config file; LIGHTEN_* environment variables override it and flags override both")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "Print the effective config with secrets redacted and exit")
+	fs.BoolVar(&cfg.displayVersion, "version", false, "Display version and exit")
+
+	return &flagconf.Loader{
+		FlagSet:         fs,
+		EnvPrefix:       "LIGHTEN",
+		CommandLineOnly: []string{"config", "print-config", "version", "stmp-host"},
+		Secrets:         []string{"db-dsn", "smtp-password", "mail-api-key", "cursor-secret", "jwt-key"},
+	}
+}
+
+// loadConfig resolves cfg from args, the config file and the environment.
+// Problems with any setting are collected in the returned validator rather
+// than stopping at the first.
+func loadConfig(cfg *config, args []string, errorHandling flag.ErrorHandling) (*flagconf.Loader, *validator.Validator, error) {
+	loader := newConfigLoader(cfg, errorHandling)
+
+	err := loader.FlagSet.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	v := validator.New()
+
+	if !cfg.displayVersion {
+		loader.Load(cfg.configFile, v)
+		readSecretFile(v, "db-dsn-file", cfg.db.dsnFile, &cfg.db.dsn)
+		readSecretFile(v, "smtp-password-file", cfg.smtp.passwordFile, &cfg.smtp.password)
+		readSecretFile(v, "cursor-secret-file", cfg.pagination.cursorSecretFile, &cfg.pagination.cursorSecret)
+		cfg.validate(v)
+	}
+
+	return loader, v, nil
+}
+
+// readSecretFile replaces *secret with the contents of the file at path,
+// less any trailing newline, when path isn't empty.
+func readSecretFile(v *validator.Validator, key, path string, secret *string) {
+	if path == "" {
+		return
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		v.AddError(key, err.Error())
+		return
+	}
+
+	*secret = strings.TrimRight(string(content), "\r\n")
+}
+
+// validate checks the settings that flag parsing alone can't, keyed by
+// flag name.
+func (cfg *config) validate(v *validator.Validator) {
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(validator.In(cfg.db.driver, "postgres", "memory"), "db-driver", "must be postgres or memory")
+	if cfg.db.driver == "postgres" {
+		v.Check(cfg.db.dsn != "", "db-dsn", "must be provided when db-driver is postgres")
+	}
+	v.Check(cfg.db.maxOpenConns > 0, "db-max-open-conns", "must be greater than zero")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	checkDuration(v, "db-max-idle-time", cfg.db.maxIdleTime)
+	checkDuration(v, "db-query-timeout", cfg.db.queryTimeout)
+
+	if cfg.limiter.enabled {
+		v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+		v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+	}
+
+	v.Check(validator.In(cfg.mail.transport, "smtp", "file", "http"), "mail-transport", "must be smtp, file or http")
+	switch cfg.mail.transport {
+	case "smtp":
+		v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+		v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	case "file":
+		v.Check(cfg.mail.dir != "", "mail-dir", "must be provided when mail-transport is file")
+	case "http":
+		v.Check(isHTTPURL(cfg.mail.apiURL), "mail-api-url", "must be an http or https URL when mail-transport is http")
+	}
+	_, err := mail.ParseAddress(cfg.smtp.sender)
+	v.Check(err == nil, "smtp-sender", "must be a valid email address")
+	if cfg.smtp.templatesDir != "" {
+		info, err := os.Stat(cfg.smtp.templatesDir)
+		v.Check(err == nil && info.IsDir(), "smtp-templates-dir", "must be an existing directory")
+	}
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		v.Check(isHTTPURL(origin), "cors-trusted-origins", "must only contain http or https origins")
+	}
+
+	v.Check(cfg.tokens.authenticationTTL > 0, "token-authentication-ttl", "must be greater than zero")
+	v.Check(cfg.tokens.refreshTTL > 0, "token-refresh-ttl", "must be greater than zero")
+
+	v.Check(validator.In(cfg.auth.mode, "token", "jwt"), "auth-mode", "must be token or jwt")
+	if cfg.auth.mode == "jwt" {
+		v.Check(len(cfg.auth.jwt.keys) > 0, "jwt-key", "must be provided when auth-mode is jwt")
+		v.Check(cfg.auth.jwt.signingKeyID != "", "jwt-signing-kid", "must be provided when auth-mode is jwt")
+		v.Check(cfg.auth.jwt.ttl > 0, "jwt-ttl", "must be greater than zero")
+	}
+
+	v.Check(cfg.log.sampling.Tick >= 0, "log-sample-tick", "must not be negative")
+	v.Check(cfg.log.sampling.First >= 0, "log-sample-first", "must not be negative")
+	v.Check(cfg.log.sampling.Thereafter >= 0, "log-sample-thereafter", "must not be negative")
+
+	if cfg.tracing.endpoint != "" {
+		v.Check(isHTTPURL(cfg.tracing.endpoint), "otlp-endpoint", "must be an http or https URL")
+	}
+	v.Check(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "trace-sample-ratio", "must be between 0 and 1")
+
+	v.Check(cfg.trash.retention > 0, "trash-retention", "must be greater than zero")
+	v.Check(cfg.trash.purgeInterval > 0, "trash-purge-interval", "must be greater than zero")
+
+	v.Check(cfg.login.freeAttempts >= 0, "login-free-attempts", "must not be negative")
+	v.Check(cfg.login.backoff > 0, "login-backoff", "must be greater than zero")
+	v.Check(cfg.login.maxBackoff >= cfg.login.backoff, "login-max-backoff", "must not be less than login-backoff")
+	v.Check(cfg.login.lockoutThreshold > cfg.login.freeAttempts, "login-lockout-threshold", "must be greater than login-free-attempts")
+	v.Check(cfg.login.lockoutDuration > 0, "login-lockout-duration", "must be greater than zero")
+
+	v.Check(cfg.jobs.workers > 0, "jobs-workers", "must be greater than zero")
+	v.Check(cfg.jobs.pollInterval > 0, "jobs-poll-interval", "must be greater than zero")
+	v.Check(cfg.jobs.timeout > 0, "jobs-timeout", "must be greater than zero")
+	v.Check(cfg.jobs.maxAttempts > 0, "jobs-max-attempts", "must be greater than zero")
+	v.Check(cfg.jobs.backoff > 0, "jobs-backoff", "must be greater than zero")
+	v.Check(cfg.jobs.maxBackoff >= cfg.jobs.backoff, "jobs-max-backoff", "must not be less than jobs-backoff")
+
+	if cfg.cache.enabled {
+		v.Check(cfg.cache.size > 0, "cache-size", "must be greater than zero")
+		v.Check(cfg.cache.ttl > 0, "cache-ttl", "must be greater than zero")
+	}
+}
+
+func checkDuration(v *validator.Validator, key, value string) {
+	d, err := time.ParseDuration(value)
+	v.Check(err == nil && d >= 0, key, "must be a valid duration, e.g. 15m")
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
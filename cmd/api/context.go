@@ -9,10 +9,16 @@ import (
 
 type contextKey string
 
-var usercontextKey = contextKey("user")
+var (
+	usercontextKey        = contextKey("user")
+	permissionsContextKey = contextKey("permissions")
+	requestInfoContextKey = contextKey("requestInfo")
+)
 
 // contextSetUser registers an authenticated user per connection
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	app.contextGetRequestInfo(r).userID = user.ID
+
 	ctx := context.WithValue(r.Context(), usercontextKey, user)
 	return r.WithContext(ctx)
 }
@@ -26,3 +32,48 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// contextSetPermissions records permissions already known for the request,
+// e.g. from a verified JWT, so requirePermission can skip the database.
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions retrieves the permissions recorded for the request, if any.
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+// requestInfo collects facts about a request as it passes through the
+// middleware chain. requestID creates it before anything else runs; the
+// matched route and authenticate fill in the rest, so that outer
+// middleware like metrics and logRequest can read it once the handler
+// has returned.
+type requestInfo struct {
+	id      string
+	pattern string
+	userID  int64
+}
+
+// contextSetRequestInfo attaches info to the request.
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestInfo retrieves the requestInfo attached to the request.
+// It never returns nil, so handlers served outside the middleware chain
+// can still record into it.
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	return requestInfoFromContext(r.Context())
+}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, ok := ctx.Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+	return info
+}
@@ -1,17 +1,44 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/lighten/internal/jsonlog"
+	"github.com/lighten/internal/tracing"
 )
 
 // The logError method is a generic helper for logging an error message and
 // additional information from the request including the HTTP method and URL.
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
-		"request_method": r.Method,
-		"request_url":    r.URL.String(),
-	})
+	fields := append(logFields(r.Context()),
+		jsonlog.String("request_method", r.Method),
+		jsonlog.String("request_url", r.URL.String()),
+	)
+
+	app.logger.Error(err, fields...)
+}
+
+// logFields returns the request ID and trace IDs carried by ctx as log
+// fields, so that every entry logged while serving a request, or a
+// background job started by one, can be traced back to it.
+func logFields(ctx context.Context) []jsonlog.Field {
+	var fields []jsonlog.Field
+
+	if id := requestInfoFromContext(ctx).id; id != "" {
+		fields = append(fields, jsonlog.String("request_id", id))
+	}
+
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields,
+			jsonlog.String("trace_id", sc.TraceID.String()),
+			jsonlog.String("span_id", sc.SpanID.String()),
+		)
+	}
+
+	return fields
 }
 
 // serverErrorResponse() method reports runtime errors/problems.
@@ -35,6 +62,10 @@ func (app *application) methodNotAllowed(w http.ResponseWriter, r *http.Request)
 // messages to the client with a given status code
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, statusCode int, message interface{}) {
 	env := envelope{"error": message}
+	if id := app.contextGetRequestInfo(r).id; id != "" {
+		env["request_id"] = id
+	}
+
 	err := app.writeJSON(w, statusCode, env, nil)
 	if err != nil {
 		app.logError(r, err)
@@ -58,12 +89,35 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 
 }
 
+// preconditionFailedResponse reports that the record doesn't match the
+// ETag in the request's If-Match header.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	msg := "the record has changed since it was fetched, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, msg)
+}
+
 // rateLimitExceededResponse reports rate limiting errors.
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	msg := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, msg)
 }
 
+// loginThrottledResponse reports a login refused because the account
+// failed too many recent attempts, with how long to wait before the next.
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(wait))
+	msg := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, msg)
+}
+
+// accountLockedResponse reports a login refused because the account is
+// locked.
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(wait))
+	msg := "your account is locked after too many failed login attempts, check your email to unlock it"
+	app.errorResponse(w, r, http.StatusLocked, msg)
+}
+
 // invalidCredentialResponse reports user authentication errors.
 func (app *application) invalidCredentialResponse(w http.ResponseWriter, r *http.Request) {
 	msg := "invalid authentication credentials"
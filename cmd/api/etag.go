@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lighten/internal/data"
+)
+
+// movieETag identifies a representation of a movie, e.g. "3-9f1c04ab". It
+// starts with the movie's version, which changes with every edit, followed
+// by a hash of the rating aggregates, which change with reviews without
+// bumping the version.
+func movieETag(movie *data.Movie) string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%v/%d", movie.AverageRating, movie.RatingCount)
+
+	return fmt.Sprintf(`"%d-%08x"`, movie.Version, h.Sum32())
+}
+
+// moviesETag identifies a page of movies by the id and ETag of each movie
+// along with the page's metadata.
+func moviesETag(movies []*data.Movie, metadata data.Metadata) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%+v", metadata)
+	for _, movie := range movies {
+		fmt.Fprintf(h, "|%d:%s", movie.ID, movieETag(movie))
+	}
+
+	return fmt.Sprintf(`W/"%016x"`, h.Sum64())
+}
+
+// etagVersion extracts the movie version from a strong ETag made by
+// movieETag.
+func etagVersion(etag string) (int32, bool) {
+	if strings.HasPrefix(etag, "W/") || len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return 0, false
+	}
+
+	prefix, _, _ := strings.Cut(etag[1:len(etag)-1], "-")
+	version, err := strconv.ParseInt(prefix, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return int32(version), true
+}
+
+// splitETags splits a comma-separated If-Match or If-None-Match header.
+func splitETags(header string) []string {
+	var etags []string
+	for _, etag := range strings.Split(header, ",") {
+		if etag = strings.TrimSpace(etag); etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return etags
+}
+
+// notModified sets the ETag header and, when the request's If-None-Match
+// header matches it, sends a 304 Not Modified response and returns true.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	// If-None-Match uses the weak comparison, ignoring any W/ prefix.
+	for _, candidate := range splitETags(header) {
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifMatch evaluates the request's If-Match header against a movie,
+// returning the version the change must apply to, 0 when the request is
+// unconditional, or ok=false when no ETag matches. Only the version part of
+// an ETag is compared, so a new review doesn't fail an edit of the movie.
+func (app *application) ifMatch(r *http.Request, movie *data.Movie) (version int32, ok bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+
+	for _, candidate := range splitETags(header) {
+		if candidate == "*" {
+			return movie.Version, true
+		}
+		if v, valid := etagVersion(candidate); valid && v == movie.Version {
+			return movie.Version, true
+		}
+	}
+
+	return 0, false
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,8 +12,10 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/lighten/internal/tracing"
 	"github.com/lighten/internal/validator"
 )
 
@@ -20,12 +25,26 @@ type envelope map[string]interface{}
 // retrieveIDParam returns the "id" URL parameter from the current request context,
 // then convert it to an integer and return it.
 func (app *application) retrieveIDParam(r *http.Request) (int64, error) {
+	return app.retrieveNamedIDParam(r, "id")
+}
+
+// isIDSegment reports whether the "id" URL parameter is the static path
+// segment name, e.g. the "export" in "/v1/movies/export". httprouter can't
+// register a static segment beside "/v1/movies/:id", so such routes are
+// dispatched from the handlers of the :id route.
+func (app *application) isIDSegment(r *http.Request, name string) bool {
+	return httprouter.ParamsFromContext(r.Context()).ByName("id") == name
+}
+
+// retrieveNamedIDParam works like retrieveIDParam for any named URL parameter,
+// e.g. the "credit_id" in "/v1/movies/:id/credits/:credit_id".
+func (app *application) retrieveNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -113,6 +132,32 @@ func (app *application) readStr(queryStr url.Values, key, defaultValue string) s
 	return str
 }
 
+// preferredLanguage returns the language tag the request's Accept-Language
+// header ranks highest, lowercased, or "" when it names none.
+func (app *application) preferredLanguage(r *http.Request) string {
+	best, bestQ := "", 0.0
+
+	for _, item := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(item, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(params[len("q="):], 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if q > bestQ && validator.Matches(tag, validator.LanguageRX) {
+			best, bestQ = tag, q
+		}
+	}
+
+	return best
+}
+
 // readCSV parses the csv-like values provide in the query string
 func (app *application) readCSV(queryStr url.Values, key string, defaultSlice []string) []string {
 	csv := queryStr.Get(key)
@@ -138,19 +183,84 @@ func (app *application) readInt(queryStr url.Values, key string, defaultValue in
 	return intValue
 }
 
-// The background() helper accepts an arbitrary function as a parameter.
-func (app *application) backgroundJob(fn func()) {
+// readOptionalBool parses a boolean from the query string, returning nil
+// when the key is absent.
+func (app *application) readOptionalBool(queryStr url.Values, key string, v *validator.Validator) *bool {
+	str := queryStr.Get(key)
+	if str == "" {
+		return nil
+	}
+
+	boolValue, err := strconv.ParseBool(str)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &boolValue
+}
+
+// validRequestID reports whether a caller-supplied request ID is short and
+// plain enough to be logged and echoed back as is.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns a random 128-bit request ID in hex.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// readBearerToken extracts the token from a "Bearer <token>" Authorization header.
+func (app *application) readBearerToken(r *http.Request) (string, bool) {
+	tokenParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return "", false
+	}
+
+	return tokenParts[1], true
+}
+
+// backgroundJob runs fn in a goroutine that the server waits for on
+// shutdown, recovering any panic. fn's context keeps the request ID and
+// continues the trace of ctx under a span called name, but isn't
+// cancelled when ctx is.
+func (app *application) backgroundJob(ctx context.Context, name string, fn func(ctx context.Context)) {
 	app.wg.Add(1)
+	atomic.AddInt64(&app.backgroundJobs, 1)
+
+	info := requestInfoFromContext(ctx)
+	ctx = context.WithValue(tracing.Detach(ctx), requestInfoContextKey, &requestInfo{id: info.id, userID: info.userID})
+	ctx, span := tracing.Start(ctx, name)
 
 	go func() {
 		defer app.wg.Done()
+		defer atomic.AddInt64(&app.backgroundJobs, -1)
+		defer span.End()
 
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				err := fmt.Errorf("%s", err)
+				span.RecordError(err)
+				app.logger.Error(err, logFields(ctx)...)
 			}
 		}()
 
-		fn()
+		fn(ctx)
 	}()
 }
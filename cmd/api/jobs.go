@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/jobs"
+	"github.com/lighten/internal/mailer"
+	"github.com/lighten/internal/validator"
+)
+
+// emailJob is the payload of a send_email job.
+type emailJob struct {
+	Recipient string                 `json:"recipient"`
+	Template  string                 `json:"template"`
+	Language  string                 `json:"language,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+var sendEmailJob = jobs.Type[emailJob]{Name: "send_email"}
+
+// registerJobs registers the handler of every kind of job the api runs.
+func (app *application) registerJobs() {
+	sendEmailJob.Handle(app.jobs, app.sendEmail)
+}
+
+// sendEmail runs a send_email job with the mailer of the current
+// configuration.
+func (app *application) sendEmail(ctx context.Context, job emailJob) error {
+	err := app.live.Load().mailer.Send(ctx, job.Recipient, job.Template, job.Language, job.Data)
+	if errors.Is(err, mailer.ErrUnknownTemplate) {
+		return jobs.Permanent(err)
+	}
+
+	return err
+}
+
+// listJobs maps to the "GET /v1/admin/jobs?<query_string>" endpoint.
+func (app *application) listJobs(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		State string
+		Kind  string
+		data.Filters
+	}
+
+	queryStr := r.URL.Query()
+
+	v := validator.New()
+
+	input.State = app.readStr(queryStr, "state", "")
+	input.Kind = app.readStr(queryStr, "kind", "")
+
+	input.Page = app.readInt(queryStr, "page", 1, v)
+	input.PageSize = app.readInt(queryStr, "page_size", 20, v)
+
+	input.Sort = app.readStr(queryStr, "sort", "-id")
+	input.SortSafelist = []string{"id", "run_at", "updated_at", "-id", "-run_at", "-updated_at"}
+
+	if input.State != "" {
+		v.Check(validator.In(input.State, data.JobPending, data.JobRunning, data.JobDead), "state", "must be pending, running or dead")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	jobs, metadata, err := app.models.Jobs.GetAll(r.Context(), input.State, input.Kind, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "jobs": jobs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showJob maps to the "GET /v1/admin/jobs/:id" endpoint.
+func (app *application) showJob(w http.ResponseWriter, r *http.Request) {
+	id, err := app.retrieveIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.Jobs.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// retryJob maps to the "POST /v1/admin/jobs/:id/retry" endpoint. Only dead
+// jobs can be retried; they run again with a fresh set of attempts.
+func (app *application) retryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := app.retrieveIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.Jobs.Retry(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
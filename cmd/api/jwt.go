@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/jwt"
+)
+
+// newJWT signs an authentication token for the user, embedding their
+// activation status and permissions. It is returned as a data.Token so
+// clients see the same response shape in both authentication modes.
+func (app *application) newJWT(ctx context.Context, userID int64, family string) (*data.Token, error) {
+	user, err := app.models.Users.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := data.NewTokenFamily()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.auth.jwt.ttl)
+
+	signed, err := app.jwtKeys.Sign(jwt.Claims{
+		Issuer:      "lighten",
+		Subject:     strconv.FormatInt(user.ID, 10),
+		ID:          id,
+		Session:     family,
+		IssuedAt:    jwt.NumericDate(now),
+		ExpiresAt:   expiry.Unix(),
+		Activated:   user.Activated,
+		Permissions: permissions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    expiry,
+		Scope:     data.ScopeAuthentication,
+		Family:    family,
+	}, nil
+}
+
+// verifyJWT checks a signed token against the keyring and the denylist and
+// rebuilds the user and permissions it was issued for.
+func (app *application) verifyJWT(token string) (*data.User, data.Permissions, error) {
+	claims, err := app.jwtKeys.Verify(token, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || userID < 1 {
+		return nil, nil, jwt.ErrInvalidToken
+	}
+
+	issuedAt := claims.Issued()
+	for _, key := range []string{"jti:" + claims.ID, "sid:" + claims.Session, "user:" + claims.Subject} {
+		if app.jwtDenylist.Revoked(key, issuedAt) {
+			return nil, nil, jwt.ErrInvalidToken
+		}
+	}
+
+	user := &data.User{
+		ID:        userID,
+		Activated: claims.Activated,
+	}
+
+	return user, data.Permissions(claims.Permissions), nil
+}
+
+// revokeJWT denylists a signed token and its session for as long as
+// tokens issued before now could still be presented.
+func (app *application) revokeJWT(ctx context.Context, token string) error {
+	claims, err := app.jwtKeys.Verify(token, time.Now())
+	if err != nil {
+		return data.ErrRecordNotFound
+	}
+
+	app.jwtDenylist.Revoke("jti:" + claims.ID)
+
+	if claims.Session != "" {
+		app.jwtDenylist.Revoke("sid:" + claims.Session)
+		return app.models.Tokens.DeleteFamily(ctx, claims.Session)
+	}
+
+	return nil
+}
+
+// expireUserClaims denylists the signed tokens issued to a user so far,
+// after a change to the permissions their claims carry. Refresh tokens are
+// kept, so clients pick up the new permissions with their next refresh.
+func (app *application) expireUserClaims(userID int64) {
+	if app.jwtDenylist != nil {
+		app.jwtDenylist.Revoke("user:" + strconv.FormatInt(userID, 10))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// lockoutPolicy returns the throttling of failed logins set by the config.
+func (app *application) lockoutPolicy() data.LockoutPolicy {
+	return data.LockoutPolicy{
+		FreeAttempts: app.config.login.freeAttempts,
+		Backoff:      app.config.login.backoff,
+		MaxBackoff:   app.config.login.maxBackoff,
+		Threshold:    app.config.login.lockoutThreshold,
+		Duration:     app.config.login.lockoutDuration,
+	}
+}
+
+// retryAfterSeconds formats a wait for the Retry-After header, rounding up
+// so that clients don't retry too early.
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10)
+}
+
+// recordLoginFailure adds an entry for a failed login to the audit log.
+// user is nil when the email matched no user.
+func (app *application) recordLoginFailure(r *http.Request, email string, user *data.User, reason string) error {
+	failure := &data.LoginFailure{
+		Email:     email,
+		IPAddress: realip.FromRequest(r),
+		Reason:    reason,
+	}
+	if user != nil {
+		failure.UserID = &user.ID
+	}
+
+	return app.models.LoginFailures.Insert(r.Context(), failure)
+}
+
+// sendLockoutEmail tells a user their account was locked, with a token to
+// unlock it that lasts as long as the lock.
+func (app *application) sendLockoutEmail(ctx context.Context, user *data.User, lockedUntil time.Time) error {
+	token, err := app.models.Tokens.New(ctx, user.ID, time.Until(lockedUntil), data.ScopeUnlock)
+	if err != nil {
+		return err
+	}
+
+	return sendEmailJob.Enqueue(ctx, app.jobs, emailJob{
+		Recipient: user.Email,
+		Template:  "account_locked.tmpl",
+		Language:  user.Language,
+		Data: map[string]interface{}{
+			"unlockToken": token.Plaintext,
+			"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+		},
+	})
+}
+
+// unlockAccount maps to the 'PUT /v1/users/unlocked' endpoint. It lifts a
+// lock after too many failed logins using the token emailed with the lock.
+func (app *application) unlockAccount(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeUnlock, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unlock token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.LoginThrottles.Reset(r.Context(), user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeUnlock, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listLoginFailures maps to the "GET /v1/admin/login-failures?<query_string>"
+// endpoint, the audit log of failed logins.
+func (app *application) listLoginFailures(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email  string
+		UserID int
+		data.Filters
+	}
+
+	queryStr := r.URL.Query()
+
+	v := validator.New()
+
+	input.Email = app.readStr(queryStr, "email", "")
+	input.UserID = app.readInt(queryStr, "user_id", 0, v)
+
+	input.Page = app.readInt(queryStr, "page", 1, v)
+	input.PageSize = app.readInt(queryStr, "page_size", 20, v)
+
+	input.Sort = app.readStr(queryStr, "sort", "-id")
+	input.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(input.UserID >= 0, "user_id", "must not be negative")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	failures, metadata, err := app.models.LoginFailures.GetAll(r.Context(), input.Email, int64(input.UserID), input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "login_failures": failures}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/lighten/internal/mailer"
+)
+
+const mailUsage = "usage: api mail preview [-lang tag] [-templates-dir dir] [-part all|subject|text|html] <template>"
+
+// sampleMailData holds a value for every field the built-in templates use.
+var sampleMailData = map[string]interface{}{
+	"userID":             123,
+	"activationToken":    "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+	"passwordResetToken": "P4B3URJZJ2NVOXJ6WIRR56UZGE",
+	"unlockToken":        "K7TQ2XNMB4YJWD5LPHC3RVE6SA",
+	"lockedUntil":        "Mon, 02 Jan 2006 16:04:05 UTC",
+}
+
+// mailCommand implements "api mail preview", which renders an email
+// template with sample data, so that templates can be checked without
+// sending anything.
+func mailCommand(args []string) error {
+	if len(args) == 0 || args[0] != "preview" {
+		return errors.New(mailUsage)
+	}
+
+	flags := flag.NewFlagSet("mail preview", flag.ExitOnError)
+	language := flags.String("lang", "", "Language tag of the variant to render, e.g. fr")
+	templatesDir := flags.String("templates-dir", "", "Directory of *.tmpl mail templates overriding the built-in ones")
+	part := flags.String("part", "all", "Part to print (all|subject|text|html)")
+
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	var overrides fs.FS
+	if *templatesDir != "" {
+		overrides = os.DirFS(*templatesDir)
+	}
+
+	m, err := mailer.New(nil, "", overrides)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("%s\ntemplates: %s", mailUsage, strings.Join(m.Templates(), ", "))
+	}
+
+	msg, err := m.Render(flags.Arg(0), *language, sampleMailData)
+	if err != nil {
+		return err
+	}
+
+	switch *part {
+	case "subject":
+		fmt.Println(msg.Subject)
+	case "text":
+		fmt.Println(strings.TrimSpace(msg.PlainBody))
+	case "html":
+		fmt.Println(strings.TrimSpace(msg.HTMLBody))
+	case "all":
+		fmt.Printf("Subject: %s\n\n", msg.Subject)
+		fmt.Printf("--- text/plain ---\n%s\n\n", strings.TrimSpace(msg.PlainBody))
+		fmt.Printf("--- text/html ---\n%s\n", strings.TrimSpace(msg.HTMLBody))
+	default:
+		return errors.New(mailUsage)
+	}
+
+	return nil
+}
@@ -2,61 +2,51 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
-	"strings"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/lighten/internal/cache"
 	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/jobs"
 	"github.com/lighten/internal/jsonlog"
-	"github.com/lighten/internal/mailer"
+	"github.com/lighten/internal/jwt"
+	"github.com/lighten/internal/metrics"
+	"github.com/lighten/internal/tracing"
 )
 
 var (
 	buildTime string
-	version string
+	version   string
 )
 
-// Holds configuration values
-type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
-	}
-	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
-	}
-	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
-	}
-	cors struct {
-		trustedOrigins []string
-	}
-}
-
 // Holds the application logic and dependencies
 type application struct {
 	logger *jsonlog.Logger
 	config config
 	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	// live holds the settings a SIGHUP can reload; see liveConfig.
+	live atomic.Pointer[liveConfig]
+	wg   sync.WaitGroup
+	// backgroundJobs counts the jobs wg is waiting for, which WaitGroup
+	// doesn't expose.
+	backgroundJobs  int64
+	metricsRegistry *metrics.Registry
+	jobs            *jobs.Queue
+	// jwtKeys and jwtDenylist are only set when auth.mode is "jwt".
+	jwtKeys     *jwt.Keyring
+	jwtDenylist *jwt.Denylist
 }
 
 // openDB opens a connection pool
@@ -87,63 +77,136 @@ func openDB(cfg config) (*sql.DB, error) {
 }
 
 func main() {
-	// command-line configurations
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "mail" {
+		if err := mailCommand(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "mail:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var cfg config
 
-	flag.IntVar(&cfg.port, "port", 4000, "Set port value")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development/staging/environment)")
+	loader, problems, _ := loadConfig(&cfg, os.Args[1:], flag.ExitOnError)
 
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	if cfg.displayVersion {
+		fmt.Printf("Version:\t%s\n", version)
+		fmt.Printf("Build time:\t%s\n", buildTime)
 
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle connections time")
+		os.Exit(0)
+	}
 
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	if cfg.printConfig {
+		loader.Print(os.Stdout)
+		if !problems.Valid() {
+			keys := make([]string, 0, len(problems.Errors))
+			for key := range problems.Errors {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
 
-	flag.StringVar(&cfg.smtp.host, "stmp-host", "smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "bf839073e4735b", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "d042a0e11033ca", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Lighten API <no-reply@lighten.api.net>", "SMTP sender")
+			for _, key := range keys {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", key, problems.Errors[key])
+			}
+			os.Exit(1)
+		}
 
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(flagValue string) error {
-		cfg.cors.trustedOrigins = strings.Fields(flagValue)
+		os.Exit(0)
+	}
 
-		return nil
+	logger := jsonlog.NewWithOptions(os.Stdout, jsonlog.Options{
+		MinLevel:        cfg.log.level,
+		StackTraceLevel: cfg.log.stackTraceLevel,
+		Sampling:        &cfg.log.sampling,
 	})
+	logger.SetSlogDefault()
 
-	displayVersion := flag.Bool("version", false, "Display version and exit")
-
-	flag.Parse()
+	if !problems.Valid() {
+		logger.Fatal(errors.New("invalid configuration"), jsonlog.Object("problems", jsonlog.Strings(problems.Errors)...))
+		return
+	}
 
-	if *displayVersion {
-		fmt.Printf("Version:\t%s\n", version)
-		fmt.Printf("Build time:\t%s\n", buildTime)
-		
-		os.Exit(0)
+	// Without a configured secret, cursors are only valid for the lifetime
+	// of this process.
+	if cfg.pagination.cursorSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			logger.Fatal(err)
+			return
+		}
+		cfg.pagination.cursorSecret = hex.EncodeToString(secret)
+		logger.Info("generated ephemeral pagination cursor secret")
 	}
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	var (
+		db      *sql.DB
+		models  data.Models
+		lookups *cache.Metered
+		err     error
+	)
 
-	db, err := openDB(cfg)
-	if err != nil {
-		logger.PrintFatal(err, nil)
+	switch cfg.db.driver {
+	case "postgres":
+		db, err = openDB(cfg)
+		if err != nil {
+			logger.Fatal(err)
+			return
+		}
+		logger.Info("database connection pool established")
+		defer db.Close()
+
+		err = checkSchema(logger, db, cfg.db.autoMigrate)
+		if err != nil {
+			logger.Fatal(err)
+			return
+		}
+
+		queryTimeout, err := time.ParseDuration(cfg.db.queryTimeout)
+		if err != nil {
+			logger.Fatal(err)
+			return
+		}
+
+		// The in-memory store is as fast as a cache, so only the
+		// PostgreSQL models get one.
+		var lookupCache *data.LookupCache
+		if cfg.cache.enabled {
+			lookups = cache.NewMetered(cache.NewLRU(cfg.cache.size))
+			lookupCache = &data.LookupCache{Cache: lookups, TTL: cfg.cache.ttl}
+		}
+
+		models = data.NewModels(db, queryTimeout, lookupCache)
+	case "memory":
+		logger.Info("using in-memory data store")
+		models = data.NewMemoryModels()
+	default:
+		logger.Fatal(fmt.Errorf("unsupported database driver %q", cfg.db.driver))
 		return
 	}
-	logger.PrintInfo("database connection pool established", nil)
-	defer db.Close()
 
 	// Configuring metrics using expvar
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() any {
 		return runtime.NumGoroutine()
 	}))
-	expvar.Publish("database", expvar.Func(func() any {
-		return db.Stats()
-	}))
+	if db != nil {
+		expvar.Publish("database", expvar.Func(func() any {
+			return db.Stats()
+		}))
+	}
+	if lookups != nil {
+		expvar.Publish("cache", expvar.Func(func() any {
+			return lookups.Stats()
+		}))
+	}
 	expvar.Publish("timestamp", expvar.Func(func() any {
 		// records the current Unix timestamp when metrics was taken
 		return time.Now().Unix()
@@ -152,13 +215,82 @@ func main() {
 	app := &application{
 		logger: logger,
 		config: cfg,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		models: models,
+
+		metricsRegistry: metrics.NewRegistry(),
+		jobs: jobs.New(models.Jobs, logger, jobs.Config{
+			Workers:      cfg.jobs.workers,
+			PollInterval: cfg.jobs.pollInterval,
+			Timeout:      cfg.jobs.timeout,
+			MaxAttempts:  cfg.jobs.maxAttempts,
+			Backoff:      cfg.jobs.backoff,
+			MaxBackoff:   cfg.jobs.maxBackoff,
+			RequestID: func(ctx context.Context) string {
+				return requestInfoFromContext(ctx).id
+			},
+		}),
+	}
+	app.registerMetrics(db)
+	app.registerJobs()
+
+	live, err := newLiveConfig(cfg)
+	if err != nil {
+		logger.Fatal(err)
+		return
+	}
+	app.live.Store(live)
+	if lookups != nil {
+		app.metricsRegistry.NewCounterFunc("lighten_cache_hits_total", "Lookups served from the cache.", func() float64 {
+			return float64(lookups.Stats().Hits)
+		})
+		app.metricsRegistry.NewCounterFunc("lighten_cache_misses_total", "Lookups that fell through to the database.", func() float64 {
+			return float64(lookups.Stats().Misses)
+		})
+	}
+
+	switch cfg.auth.mode {
+	case "token":
+	case "jwt":
+		app.jwtKeys, err = jwt.NewKeyring(cfg.auth.jwt.signingKeyID, cfg.auth.jwt.keys...)
+		if err != nil {
+			logger.Fatal(err)
+			return
+		}
+		app.jwtDenylist = jwt.NewDenylist(cfg.auth.jwt.ttl)
+	default:
+		logger.Fatal(fmt.Errorf("unsupported auth mode %q", cfg.auth.mode))
+		return
+	}
+
+	// Catch a misspelt default role at startup rather than registering
+	// users without any permissions.
+	if cfg.auth.defaultRole != "" {
+		_, err = models.Roles.GetByName(context.Background(), cfg.auth.defaultRole)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				err = fmt.Errorf("default role %q does not exist", cfg.auth.defaultRole)
+			}
+			logger.Fatal(err)
+			return
+		}
+	}
+
+	tracer := app.newTracer()
+	if tracer != nil {
+		tracing.SetTracer(tracer)
 	}
 
 	err = app.serve()
 
+	if tracer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := tracer.Shutdown(ctx); err != nil {
+			logger.Error(err)
+		}
+		cancel()
+	}
+
 	if err != nil {
-		logger.PrintFatal(err, nil)
+		logger.Fatal(err)
 	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"runtime"
+	"sync/atomic"
+)
+
+// registerMetrics registers the process-wide gauges exposed on /metrics.
+// Request metrics are registered by the metrics middleware and rate
+// limiter metrics by rateLimit. db is nil for the in-memory store.
+func (app *application) registerMetrics(db *sql.DB) {
+	r := app.metricsRegistry
+
+	r.NewGaugeFunc("lighten_goroutines", "Goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	r.NewGaugeFunc("lighten_background_jobs", "Background jobs currently running.", func() float64 {
+		return float64(atomic.LoadInt64(&app.backgroundJobs))
+	})
+
+	if db == nil {
+		return
+	}
+
+	r.NewGaugeFunc("lighten_db_open_connections", "Established database connections, both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	r.NewGaugeFunc("lighten_db_in_use_connections", "Database connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	r.NewGaugeFunc("lighten_db_idle_connections", "Idle database connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	r.NewGaugeFunc("lighten_db_max_open_connections", "Maximum number of open database connections.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	r.NewCounterFunc("lighten_db_wait_count_total", "Database connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	r.NewCounterFunc("lighten_db_wait_duration_seconds_total", "Time spent waiting for database connections.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	r.NewCounterFunc("lighten_db_max_idle_closed_total", "Database connections closed due to the idle connection limit.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	r.NewCounterFunc("lighten_db_max_idle_time_closed_total", "Database connections closed due to the idle time limit.", func() float64 {
+		return float64(db.Stats().MaxIdleTimeClosed)
+	})
+}
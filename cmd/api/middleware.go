@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/felixge/httpsnoop"
 	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/jsonlog"
+	"github.com/lighten/internal/jwt"
+	"github.com/lighten/internal/metrics"
 	"github.com/lighten/internal/validator"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
@@ -43,6 +45,13 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		clients = make(map[string]*client)
 	)
 
+	app.metricsRegistry.NewGaugeFunc("lighten_rate_limiter_clients", "Clients currently tracked by the rate limiter.", func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return float64(len(clients))
+	})
+
 	go func() {
 		for {
 			time.Sleep(time.Minute)
@@ -60,16 +69,26 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 	}()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
+		limiter := app.live.Load().config.limiter
+
+		if limiter.enabled {
 			ip := realip.FromRequest(r)
 
 			mu.Lock()
 			if _, found := clients[ip]; !found {
 				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+					limiter: rate.NewLimiter(rate.Limit(limiter.rps), limiter.burst),
 				}
 			}
 
+			// Bring limiters created before a config reload up to date.
+			if clients[ip].limiter.Limit() != rate.Limit(limiter.rps) {
+				clients[ip].limiter.SetLimit(rate.Limit(limiter.rps))
+			}
+			if clients[ip].limiter.Burst() != limiter.burst {
+				clients[ip].limiter.SetBurst(limiter.burst)
+			}
+
 			clients[ip].lastSeen = time.Now()
 
 			if !clients[ip].limiter.Allow() {
@@ -91,20 +110,34 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// vary based on the value of Authorization.
 		w.Header().Set("Vary", "Authorization")
 
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
+		if r.Header.Get("Authorization") == "" {
 			// We use the data.AnonymousUser if no Authorization header found
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		token, ok := app.readBearerToken(r)
+		if !ok {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
-		token := tokenParts[1]
+
+		// Signed tokens carry everything needed to authorize the request,
+		// so they are verified without a database round-trip.
+		if app.jwtKeys != nil && jwt.LooksLikeJWT(token) {
+			user, permissions, err := app.verifyJWT(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, permissions)
+
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		v := validator.New()
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -112,7 +145,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -131,7 +164,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 // requiredAuthenticatedUser controls access to restricted endpoints – Authorization
 func (app *application) requiredAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return app.traced("requiredAuthenticatedUser", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
 		if user.IsAnonymous() {
@@ -140,7 +173,7 @@ func (app *application) requiredAuthenticatedUser(next http.HandlerFunc) http.Ha
 		}
 
 		next.ServeHTTP(w, r)
-	})
+	}))
 }
 
 // requireActivatedUser controls access to endpoints based on if user is activated or not.
@@ -156,7 +189,7 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 		next.ServeHTTP(w, r)
 	})
 
-	return app.requiredAuthenticatedUser(fn)
+	return app.requiredAuthenticatedUser(app.traced("requireActivatedUser", fn))
 }
 
 // requirePermission checks if a user is authorized to access a particular resource.
@@ -164,10 +197,14 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		if err != nil {
-			app.serverErrorResponse(w, r, err)
-			return
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			var err error
+			permissions, err = app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 		}
 
 		if !permissions.Include(code) {
@@ -178,7 +215,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		next.ServeHTTP(w, r)
 	}
 
-	return app.requireActivatedUser(fn)
+	return app.requireActivatedUser(app.traced("requirePermission", http.HandlerFunc(fn)))
 }
 
 // enableCORS enables cross-site requests for web user-agents.
@@ -190,14 +227,17 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		origin := r.Header.Get("Origin")
 
 		// Preflight
-		if origin != "" && len(app.config.cors.trustedOrigins) != 0 {
-			for _, v := range app.config.cors.trustedOrigins {
+		trustedOrigins := app.live.Load().config.cors.trustedOrigins
+
+		if origin != "" && len(trustedOrigins) != 0 {
+			for _, v := range trustedOrigins {
 				if origin == v {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match")
 
 						w.WriteHeader(http.StatusOK)
 						return
@@ -210,6 +250,51 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// requestID tags the request with the caller's X-Request-ID, or a fresh ID
+// when the caller sent none or one that is unsafe to log, and echoes it back.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+			id, err = newRequestID()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestInfo(r, &requestInfo{id: id})
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logRequest writes one access log entry per request once it has been served.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := httpsnoop.CaptureMetrics(next, w, r)
+
+		info := app.contextGetRequestInfo(r)
+
+		fields := append(logFields(r.Context()),
+			jsonlog.String("method", r.Method),
+			jsonlog.String("route", info.pattern),
+			jsonlog.String("url", r.URL.RequestURI()),
+			jsonlog.Int("status", int64(m.Code)),
+			jsonlog.Int("bytes", m.Written),
+			jsonlog.Duration("duration", m.Duration),
+			jsonlog.String("client_ip", realip.FromRequest(r)),
+		)
+		if info.userID != 0 {
+			fields = append(fields, jsonlog.Int("user_id", info.userID))
+		}
+
+		app.logger.Info("request served", fields...)
+	})
+}
+
 // metrics specific request-response metrics for monitoring.
 func (app *application) metrics(next http.Handler) http.Handler {
 	totalRequestsReceived := expvar.NewInt("total_requests_received")
@@ -217,13 +302,57 @@ func (app *application) metrics(next http.Handler) http.Handler {
 	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_μs")
 	totalResponsesSentByStatus := expvar.NewMap("total_responses_sent_by_status")
 
+	httpRequests := app.metricsRegistry.NewCounterVec(
+		"lighten_http_requests_total", "HTTP requests handled, by route pattern, method and status.",
+		"route", "method", "status",
+	)
+	httpDuration := app.metricsRegistry.NewHistogramVec(
+		"lighten_http_request_duration_seconds", "HTTP request latency, by route pattern, method and status.",
+		metrics.DefaultBuckets, "route", "method", "status",
+	)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		totalRequestsReceived.Add(1)
 
-		metrics := httpsnoop.CaptureMetrics(next, w, r)
+		m := httpsnoop.CaptureMetrics(next, w, r)
 
 		totalResponsesSent.Add(1)
-		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
-		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+		totalProcessingTimeMicroseconds.Add(m.Duration.Microseconds())
+		totalResponsesSentByStatus.Add(strconv.Itoa(m.Code), 1)
+
+		// Requests that matched no route share one label value, so
+		// scanners probing random URLs can't grow the series without bound.
+		pattern := app.contextGetRequestInfo(r).pattern
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+
+		status := strconv.Itoa(m.Code)
+		method := metricsMethod(r.Method)
+		httpRequests.Inc(pattern, method, status)
+		httpDuration.Observe(m.Duration.Seconds(), pattern, method, status)
+	})
+}
+
+// metricsMethod returns the method label for a request. The method is
+// whatever token the client sent, so anything outside the standard set
+// shares one value, for the same reason as unmatched routes do.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// routePattern records the route pattern of requests reaching next, for
+// the metrics middleware to label them with.
+func (app *application) routePattern(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.contextGetRequestInfo(r).pattern = pattern
+
+		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lighten/internal/jsonlog"
+	"github.com/lighten/internal/migrate"
+	"github.com/lighten/migrations"
+)
+
+const migrateUsage = "usage: api migrate [flags] up|down|status|goto N"
+
+// migrateCommand implements "api migrate", which applies the migrations
+// embedded in the binary to the configured database. It accepts the same
+// flags, config file and environment variables as the server.
+func migrateCommand(args []string) error {
+	var cfg config
+
+	loader, problems, err := loadConfig(&cfg, args, flag.ExitOnError)
+	if err != nil {
+		return err
+	}
+	if !problems.Valid() {
+		var lines []string
+		for key, message := range problems.Errors {
+			lines = append(lines, key+": "+message)
+		}
+		sort.Strings(lines)
+		return fmt.Errorf("invalid configuration:\n%s", strings.Join(lines, "\n"))
+	}
+	if cfg.db.driver != "postgres" {
+		return errors.New("migrations only apply to the postgres driver")
+	}
+
+	action := loader.FlagSet.Args()
+	if len(action) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	var steps []migrate.Step
+
+	switch {
+	case action[0] == "up" && len(action) == 1:
+		steps, err = migrator.Up(ctx)
+	case action[0] == "down" && len(action) == 1:
+		steps, err = migrator.Down(ctx)
+	case action[0] == "goto" && len(action) == 2:
+		target, parseErr := strconv.ParseUint(action[1], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("invalid version %q", action[1])
+		}
+		steps, err = migrator.Goto(ctx, uint(target))
+	case action[0] == "status" && len(action) == 1:
+		return printMigrationStatus(ctx, migrator)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	for _, step := range steps {
+		direction := "up"
+		if !step.Up {
+			direction = "down"
+		}
+		fmt.Printf("%06d_%s %s\n", step.Version, step.Name, direction)
+	}
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Println("no change")
+	}
+
+	return nil
+}
+
+func printMigrationStatus(ctx context.Context, migrator *migrate.Migrator) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrator.Migrations() {
+		state := "pending"
+		if m.Version <= status.Version {
+			state = "applied"
+		}
+		fmt.Printf("%06d_%s\t%s\n", m.Version, m.Name, state)
+	}
+
+	fmt.Printf("version %d, latest %d", status.Version, status.Latest)
+	if status.Dirty {
+		fmt.Print(", dirty")
+	}
+	fmt.Println()
+
+	return nil
+}
+
+// checkSchema applies pending migrations when autoMigrate is set, then
+// refuses to start unless the schema is at least the version this build
+// expects.
+func checkSchema(logger *jsonlog.Logger, db *sql.DB, autoMigrate bool) error {
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if autoMigrate && !status.Dirty && status.Version < status.Latest {
+		steps, err := migrator.Up(ctx)
+		for _, step := range steps {
+			logger.Info("applied migration", jsonlog.Int("version", int64(step.Version)), jsonlog.String("name", step.Name))
+		}
+		if err != nil {
+			return err
+		}
+
+		status, err = migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case status.Dirty:
+		return migrate.ErrDirty
+	case status.Version < status.Latest:
+		return fmt.Errorf("database schema is at version %d but this build expects %d; run \"api migrate up\" or start with -auto-migrate", status.Version, status.Latest)
+	case status.Version > status.Latest:
+		// A newer build may have migrated ahead of this one during a
+		// rolling deploy; migrations are expected to stay compatible.
+		logger.Warn("database schema is newer than this build", jsonlog.Int("version", int64(status.Version)), jsonlog.Int("latest", int64(status.Latest)))
+	}
+
+	return nil
+}
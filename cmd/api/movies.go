@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/jsonlog"
 	"github.com/lighten/internal/validator"
 )
 
-// showMovie maps to the "GET /v1/movies/:id" endpoint.
+// showMovie maps to the "GET /v1/movies/:id" endpoint. It also serves
+// "GET /v1/movies/export", which exportMovies handles.
 func (app *application) showMovie(w http.ResponseWriter, r *http.Request) {
+	if app.isIDSegment(r, "export") {
+		app.contextGetRequestInfo(r).pattern = "/v1/movies/export"
+		app.exportMovies(w, r)
+		return
+	}
+
 	id, err := app.retrieveIDParam(r)
 
 	if err != nil {
@@ -18,7 +28,7 @@ func (app *application) showMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -29,6 +39,10 @@ func (app *application) showMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if app.notModified(w, r, movieETag(movie)) {
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -64,7 +78,7 @@ func (app *application) createMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -72,6 +86,7 @@ func (app *application) createMovie(w http.ResponseWriter, r *http.Request) {
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
@@ -88,7 +103,7 @@ func (app *application) updateMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -99,6 +114,11 @@ func (app *application) updateMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, ok := app.ifMatch(r, movie); !ok {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Title   *string       `json:"title"`
 		Year    *int32        `json:"year"`
@@ -135,9 +155,11 @@ func (app *application) updateMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -146,24 +168,54 @@ func (app *application) updateMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// deleteMovie maps to the "DELETE /v1/movies/:id" endpoint.
+// deleteMovie maps to the "DELETE /v1/movies/:id" endpoint. With an
+// If-Match header the movie is only deleted if it hasn't changed since.
 func (app *application) deleteMovie(w http.ResponseWriter, r *http.Request) {
 	id, err := app.retrieveIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.models.Movies.Delete(id)
+
+	var version int32
+
+	if r.Header.Get("If-Match") != "" {
+		movie, err := app.models.Movies.Get(r.Context(), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		var ok bool
+		if version, ok = app.ifMatch(r, movie); !ok {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+	}
+
+	err = app.models.Movies.Delete(r.Context(), id, version, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -175,11 +227,123 @@ func (app *application) deleteMovie(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// restoreMovie maps to the "POST /v1/movies/:id/restore" endpoint.
+func (app *application) restoreMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := app.retrieveIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Movies.Restore(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listDeletedMovies maps to the "GET /v1/trash/movies?<query_string>" endpoint.
+func (app *application) listDeletedMovies(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	queryStr := r.URL.Query()
+
+	v := validator.New()
+
+	input.Page = app.readInt(queryStr, "page", 1, v)
+	input.PageSize = app.readInt(queryStr, "page_size", 20, v)
+
+	// The trash is always listed most recently deleted first.
+	input.Sort = "-deleted_at"
+	input.SortSafelist = []string{"-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetDeleted(r.Context(), input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeTrash permanently deletes the movies that have been in the trash
+// for longer than the retention period, once per purge interval, until
+// ctx is cancelled. It also forgets the failed logins with emails that
+// have seen no attempt for as long as a lockout lasts.
+func (app *application) purgeTrash(ctx context.Context) {
+	ticker := time.NewTicker(app.config.trash.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		app.backgroundJob(ctx, "purgeTrash", func(ctx context.Context) {
+			purged, err := app.models.Movies.Purge(ctx, time.Now().Add(-app.config.trash.retention))
+			if err != nil {
+				app.logger.Error(err, logFields(ctx)...)
+				return
+			}
+			if purged > 0 {
+				app.logger.Info("purged movies from the trash", jsonlog.Int("count", purged))
+			}
+		})
+
+		app.backgroundJob(ctx, "purgeLoginThrottles", func(ctx context.Context) {
+			purged, err := app.models.LoginThrottles.Purge(ctx, time.Now().Add(-app.config.login.lockoutDuration))
+			if err != nil {
+				app.logger.Error(err, logFields(ctx)...)
+				return
+			}
+			if purged > 0 {
+				app.logger.Info("purged stale login throttles", jsonlog.Int("count", purged))
+			}
+		})
+	}
+}
+
 // listMovies maps to the "GET /v1/movies?<query_string>" endpoint.
 func (app *application) listMovies(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		Title    string
+		Genres   []string
+		PersonID int64
+		Director string
 		data.Filters
 	}
 
@@ -189,24 +353,38 @@ func (app *application) listMovies(w http.ResponseWriter, r *http.Request) {
 
 	input.Title = app.readStr(queryStr, "title", "")
 	input.Genres = app.readCSV(queryStr, "genres", []string{})
+	input.PersonID = int64(app.readInt(queryStr, "person_id", 0, v))
+	input.Director = app.readStr(queryStr, "director", "")
 
 	input.Page = app.readInt(queryStr, "page", 1, v)
 	input.PageSize = app.readInt(queryStr, "page_size", 20, v)
 
 	input.Sort = app.readStr(queryStr, "sort", "id")
-	input.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.SortSafelist = []string{
+		"id", "title", "year", "runtime", "average_rating", "rating_count",
+		"-id", "-title", "-year", "-runtime", "-average_rating", "-rating_count",
+	}
+
+	input.Cursor = app.readStr(queryStr, "cursor", "")
+	input.CursorKey = []byte(app.config.pagination.cursorSecret)
+
+	v.Check(input.PersonID >= 0, "person_id", "must not be negative")
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.PersonID, input.Director, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if app.notModified(w, r, moviesETag(movies, metadata)) {
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/jsonlog"
+)
+
+// newTestApplication returns an application backed by the in-memory
+// models, seeded with one movie and three activated users: a reader, a
+// writer, and one with no permissions at all.
+func newTestApplication(t *testing.T) (*application, map[string]*data.User) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	app := &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelFatal),
+		models: data.NewMemoryModels(),
+	}
+
+	users := map[string]*data.User{
+		"reader": {Name: "Reader", Email: "reader@example.com", Activated: true},
+		"writer": {Name: "Writer", Email: "writer@example.com", Activated: true},
+		"nobody": {Name: "Nobody", Email: "nobody@example.com", Activated: true},
+	}
+
+	for _, user := range users {
+		if err := app.models.Users.Insert(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := app.models.Permissions.AddForUser(ctx, users["reader"].ID, "movies:read"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Permissions.AddForUser(ctx, users["writer"].ID, "movies:read", "movies:write"); err != nil {
+		t.Fatal(err)
+	}
+
+	movie := &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}}
+	if err := app.models.Movies.Insert(ctx, movie, users["writer"].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	return app, users
+}
+
+func TestMovieHandlers(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"show", "reader", http.MethodGet, "/v1/movies/1", "", http.StatusOK, `"title":"Moana"`},
+		{"show missing", "reader", http.MethodGet, "/v1/movies/99", "", http.StatusNotFound, `"error"`},
+		{"show bad id", "reader", http.MethodGet, "/v1/movies/abc", "", http.StatusNotFound, `"error"`},
+		{"show without permission", "nobody", http.MethodGet, "/v1/movies/1", "", http.StatusForbidden, `"error"`},
+		{"show anonymous", "", http.MethodGet, "/v1/movies/1", "", http.StatusUnauthorized, `"error"`},
+		{
+			"create", "writer", http.MethodPost, "/v1/movies",
+			`{"title":"Black Panther","year":2018,"runtime":"134 mins","genres":["action","adventure"]}`,
+			http.StatusCreated, `"id":2`,
+		},
+		{
+			"create invalid", "writer", http.MethodPost, "/v1/movies",
+			`{"title":"","year":2018,"runtime":"134 mins","genres":["action"]}`,
+			http.StatusUnprocessableEntity, `"title":"must be provided"`,
+		},
+		{"create malformed", "writer", http.MethodPost, "/v1/movies", `{"title":`, http.StatusBadRequest, `"error"`},
+		{
+			"create read only", "reader", http.MethodPost, "/v1/movies",
+			`{"title":"Black Panther","year":2018,"runtime":"134 mins","genres":["action"]}`,
+			http.StatusForbidden, `"error"`,
+		},
+		{"update", "writer", http.MethodPatch, "/v1/movies/1", `{"year":2017}`, http.StatusOK, `"year":2017`},
+		{"update missing", "writer", http.MethodPatch, "/v1/movies/99", `{"year":2017}`, http.StatusNotFound, `"error"`},
+		{"delete", "writer", http.MethodDelete, "/v1/movies/1", "", http.StatusOK, `"movie deleted successfully"`},
+		{"delete missing", "writer", http.MethodDelete, "/v1/movies/99", "", http.StatusNotFound, `"error"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, users := newTestApplication(t)
+
+			router := httprouter.New()
+			router.Handler(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovie))
+			router.Handler(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovie))
+			router.Handler(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovie))
+			router.Handler(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovie))
+
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+
+			user := data.AnonymousUser
+			if tt.user != "" {
+				user = users[tt.user]
+			}
+			r = app.contextSetUser(r, user)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", w.Code, tt.wantStatus)
+			}
+			var body bytes.Buffer
+			if err := json.Compact(&body, w.Body.Bytes()); err != nil {
+				t.Fatalf("got invalid JSON body %q: %v", w.Body, err)
+			}
+			if !strings.Contains(body.String(), tt.wantBody) {
+				t.Errorf("got body %s; want it to contain %s", body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCreatedMovieIsStored(t *testing.T) {
+	app, users := newTestApplication(t)
+
+	body := `{"title":"Black Panther","year":2018,"runtime":"134 mins","genres":["action","adventure"]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body))
+	r = app.contextSetUser(r, users["writer"])
+
+	w := httptest.NewRecorder()
+	app.createMovie(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if got := w.Header().Get("Location"); got != "/v1/movies/2" {
+		t.Errorf("got Location %q; want %q", got, "/v1/movies/2")
+	}
+
+	movie, err := app.models.Movies.Get(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("Movies.Get: %v", err)
+	}
+	if movie.Title != "Black Panther" || movie.Runtime != 134 {
+		t.Errorf("stored %+v", movie)
+	}
+}
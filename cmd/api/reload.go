@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/lighten/internal/jsonlog"
+	"github.com/lighten/internal/mailer"
+)
+
+// liveConfig is the configuration as of the last reload, with the mailer
+// built from it. Handlers read the reloadable settings (CORS origins,
+// limiter, mail transport and templates) from app.live rather than app.config;
+// a reload swaps the whole value at once, so a request never sees a mix of
+// old and new settings.
+type liveConfig struct {
+	config config
+	mailer mailer.Mailer
+}
+
+func newLiveConfig(cfg config) (*liveConfig, error) {
+	var templates fs.FS
+	if cfg.smtp.templatesDir != "" {
+		templates = os.DirFS(cfg.smtp.templatesDir)
+	}
+
+	var transport mailer.Transport
+	switch cfg.mail.transport {
+	case "file":
+		t, err := mailer.NewFileTransport(cfg.mail.dir)
+		if err != nil {
+			return nil, err
+		}
+		transport = t
+	case "http":
+		transport = mailer.NewHTTPTransport(cfg.mail.apiURL, cfg.mail.apiKey)
+	default:
+		transport = mailer.NewSMTPTransport(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password)
+	}
+
+	m, err := mailer.New(transport, cfg.smtp.sender, templates)
+	if err != nil {
+		return nil, err
+	}
+
+	return &liveConfig{config: cfg, mailer: m}, nil
+}
+
+// reloadConfig re-reads the configuration from the same command line,
+// config file and environment as at startup, and applies the reloadable
+// settings. An invalid configuration is rejected as a whole.
+func (app *application) reloadConfig() {
+	var cfg config
+
+	_, problems, err := loadConfig(&cfg, os.Args[1:], flag.ContinueOnError)
+	if err != nil {
+		app.logger.Error(fmt.Errorf("config reload rejected: %w", err))
+		return
+	}
+	if !problems.Valid() {
+		app.logger.Error(errors.New("config reload rejected: invalid configuration"), jsonlog.Object("problems", jsonlog.Strings(problems.Errors)...))
+		return
+	}
+
+	prev := app.live.Load()
+
+	// Keep a secret generated at startup rather than reporting it changed.
+	if cfg.pagination.cursorSecret == "" {
+		cfg.pagination.cursorSecret = prev.config.pagination.cursorSecret
+	}
+
+	next, err := newLiveConfig(cfg)
+	if err != nil {
+		app.logger.Error(fmt.Errorf("config reload rejected: %w", err))
+		return
+	}
+
+	reloaded, ignored := configChanges(prev.config, cfg)
+
+	// Only touch the level when the configured one changed, so that a level
+	// set through the admin endpoint survives unrelated reloads.
+	if cfg.log.level != prev.config.log.level {
+		app.logger.SetLevel(cfg.log.level)
+	}
+	app.live.Store(next)
+
+	app.logger.Info("configuration reloaded", jsonlog.String("changed", strings.Join(reloaded, ",")))
+	if len(ignored) > 0 {
+		app.logger.Warn("configuration changes need a restart to apply", jsonlog.String("settings", strings.Join(ignored, ",")))
+	}
+}
+
+// configChanges compares two configurations, returning the reloadable
+// settings that differ and the other settings that differ, which only take
+// effect on restart. Mail templates are always re-read, so they aren't
+// reported unless their directory changed.
+func configChanges(prev, next config) (reloaded, ignored []string) {
+	reloadable := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"cors-trusted-origins", strings.Join(prev.cors.trustedOrigins, " "), strings.Join(next.cors.trustedOrigins, " ")},
+		{"limiter-rps", prev.limiter.rps, next.limiter.rps},
+		{"limiter-burst", prev.limiter.burst, next.limiter.burst},
+		{"limiter-enabled", prev.limiter.enabled, next.limiter.enabled},
+		{"log-level", prev.log.level, next.log.level},
+		{"mail-transport", prev.mail.transport, next.mail.transport},
+		{"mail-dir", prev.mail.dir, next.mail.dir},
+		{"mail-api-url", prev.mail.apiURL, next.mail.apiURL},
+		{"mail-api-key", prev.mail.apiKey, next.mail.apiKey},
+		{"smtp-host", prev.smtp.host, next.smtp.host},
+		{"smtp-port", prev.smtp.port, next.smtp.port},
+		{"smtp-username", prev.smtp.username, next.smtp.username},
+		{"smtp-password", prev.smtp.password, next.smtp.password},
+		{"smtp-sender", prev.smtp.sender, next.smtp.sender},
+		{"smtp-templates-dir", prev.smtp.templatesDir, next.smtp.templatesDir},
+	}
+	for _, setting := range reloadable {
+		if setting.a != setting.b {
+			reloaded = append(reloaded, setting.name)
+		}
+	}
+
+	// Copy the reloadable settings across so that comparing whole sections
+	// only finds the changes that need a restart.
+	prev.cors = next.cors
+	prev.limiter = next.limiter
+	prev.log.level = next.log.level
+	prev.mail = next.mail
+	prev.smtp = next.smtp
+
+	restart := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"port", prev.port, next.port},
+		{"env", prev.env, next.env},
+		{"db", prev.db, next.db},
+		{"pagination", prev.pagination, next.pagination},
+		{"log", prev.log, next.log},
+		{"tracing", prev.tracing, next.tracing},
+		{"trash", prev.trash, next.trash},
+		{"login", prev.login, next.login},
+		{"jobs", prev.jobs, next.jobs},
+		{"cache", prev.cache, next.cache},
+		{"tokens", prev.tokens, next.tokens},
+		{"auth", prev.auth, next.auth},
+	}
+	for _, section := range restart {
+		if !reflect.DeepEqual(section.a, section.b) {
+			ignored = append(ignored, section.name)
+		}
+	}
+
+	return reloaded, ignored
+}
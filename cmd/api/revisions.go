@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/validator"
+)
+
+// retrieveVersionParam reads the ":version" URL parameter of the history
+// endpoints.
+func (app *application) retrieveVersionParam(r *http.Request) (int32, error) {
+	version, err := app.retrieveNamedIDParam(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
+// listMovieRevisions maps to the "GET /v1/movies/:id/history?<query_string>"
+// endpoint. The history of movies in the trash, or purged, is listed too.
+func (app *application) listMovieRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := app.retrieveIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	queryStr := r.URL.Query()
+
+	v := validator.New()
+
+	input.Page = app.readInt(queryStr, "page", 1, v)
+	input.PageSize = app.readInt(queryStr, "page_size", 20, v)
+
+	// History is always listed newest version first.
+	input.Sort = "-version"
+	input.SortSafelist = []string{"-version"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.MovieRevisions.GetAllForMovie(r.Context(), id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The history outlives the movie, in the trash or purged, so a movie is
+	// only unknown if it has no revisions at all.
+	if len(revisions) == 0 {
+		if input.Page > 1 {
+			first := data.Filters{Page: 1, PageSize: 1, Sort: input.Sort, SortSafelist: input.SortSafelist}
+			revisions, _, err = app.models.MovieRevisions.GetAllForMovie(r.Context(), id, first)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+		if len(revisions) == 0 {
+			app.notFoundResponse(w, r)
+			return
+		}
+		revisions = revisions[:0]
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "revisions": revisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMovieRevision maps to the "GET /v1/movies/:id/history/:version" endpoint.
+func (app *application) showMovieRevision(w http.ResponseWriter, r *http.Request) {
+	id, err := app.retrieveIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.retrieveVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Like the rest of the history, revisions stay readable once the movie
+	// is in the trash or purged.
+	revision, err := app.models.MovieRevisions.Get(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertMovie maps to the "POST /v1/movies/:id/history/:version/revert"
+// endpoint. The movie takes the title, year, runtime and genres it had at
+// that version, as a new version. Like updateMovie, it honours If-Match.
+func (app *application) revertMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := app.retrieveIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.retrieveVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if _, ok := app.ifMatch(r, movie); !ok {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	err = app.models.Movies.Revert(r.Context(), movie, version, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/validator"
+)
+
+// listRoles maps to the "GET /v1/admin/roles" endpoint.
+func (app *application) listRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showRole maps to the "GET /v1/admin/roles/:id" endpoint.
+func (app *application) showRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.adminTargetRole(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createRole maps to the "POST /v1/admin/roles" endpoint.
+func (app *application) createRole(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	err = app.validateRole(r.Context(), v, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Insert(r.Context(), role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/roles/%d", role.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateRole maps to the "PATCH /v1/admin/roles/:id" endpoint. Permissions,
+// when provided, replace the role's current set.
+func (app *application) updateRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.adminTargetRole(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        *string  `json:"name"`
+		Description *string  `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		role.Name = *input.Name
+	}
+	if input.Description != nil {
+		role.Description = *input.Description
+	}
+	if input.Permissions != nil {
+		role.Permissions = input.Permissions
+	}
+
+	v := validator.New()
+
+	err = app.validateRole(r.Context(), v, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Update(r.Context(), role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Permissions != nil {
+		err = app.expireRoleHolderClaims(r.Context(), role.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteRole maps to the "DELETE /v1/admin/roles/:id" endpoint.
+func (app *application) deleteRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.adminTargetRole(w, r)
+	if !ok {
+		return
+	}
+
+	// New registrations would silently get no permissions without it.
+	if role.Name == app.config.auth.defaultRole {
+		app.errorResponse(w, r, http.StatusConflict, "the default role for new users cannot be deleted")
+		return
+	}
+
+	// Read the holders first, as deleting the role unassigns it.
+	holders, err := app.models.Roles.GetHolders(r.Context(), role.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Roles.Delete(r.Context(), role.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, userID := range holders {
+		app.expireUserClaims(userID)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// assignUserRoles maps to the "PUT /v1/admin/users/:id/roles" endpoint.
+func (app *application) assignUserRoles(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRoles(w, r, app.models.Roles.AddForUser)
+}
+
+// unassignUserRoles maps to the "DELETE /v1/admin/users/:id/roles" endpoint.
+func (app *application) unassignUserRoles(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRoles(w, r, app.models.Roles.RemoveForUser)
+}
+
+// changeUserRoles validates the role names in the request body, applies
+// change to the addressed user and responds with their roles and the
+// permissions those now add up to.
+func (app *application) changeUserRoles(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, userID int64, names ...string) error) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	known := make([]string, 0, len(roles))
+	for _, role := range roles {
+		known = append(known, role.Name)
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Roles) > 0, "roles", "must contain at least 1 role")
+	for _, name := range input.Roles {
+		v.Check(validator.In(name, known...), "roles", "must only contain known role names")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = change(r.Context(), user.ID, input.Roles...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.expireUserClaims(user.ID)
+
+	assigned, err := app.models.Roles.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": assigned, "permissions": permissionsOrEmpty(permissions)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// expireRoleHolderClaims expires the signed tokens of every user holding a
+// role whose permissions changed.
+func (app *application) expireRoleHolderClaims(ctx context.Context, roleID int64) error {
+	holders, err := app.models.Roles.GetHolders(ctx, roleID)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range holders {
+		app.expireUserClaims(userID)
+	}
+
+	return nil
+}
+
+// validateRole runs data.ValidateRole and checks that every permission
+// code in the role exists.
+func (app *application) validateRole(ctx context.Context, v *validator.Validator, role *data.Role) error {
+	data.ValidateRole(v, role)
+
+	known, err := app.models.Permissions.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range role.Permissions {
+		v.Check(known.Include(code), "permissions", "must only contain known permission codes")
+	}
+
+	return nil
+}
+
+// adminTargetRole fetches the role addressed by the URL. It writes the
+// error response itself and reports false when the handler should stop.
+func (app *application) adminTargetRole(w http.ResponseWriter, r *http.Request) (*data.Role, bool) {
+	id, err := app.retrieveIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	role, err := app.models.Roles.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return role, true
+}
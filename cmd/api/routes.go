@@ -13,23 +13,86 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowed)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheck)
+	// handle registers a route, labelling its requests' metrics with the
+	// route pattern rather than the raw URL and tracing its handler.
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.Handler(method, pattern, app.routePattern(pattern, app.traced("handler "+method+" "+pattern, handler)))
+	}
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMovies))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovie))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovie))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovie))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovie))
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheck)
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUser)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUser)
-	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPassword)
+	handle(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMovies))
+	handle(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovie))
+	handle(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovie))
+	handle(http.MethodPost, "/v1/movies/:id", app.requirePermission("movies:write", app.importMovies))
+	handle(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovie))
+	handle(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovie))
+	handle(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovie))
+	handle(http.MethodGet, "/v1/movies/:id/history", app.requirePermission("movies:read", app.listMovieRevisions))
+	handle(http.MethodGet, "/v1/movies/:id/history/:version", app.requirePermission("movies:read", app.showMovieRevision))
+	handle(http.MethodPost, "/v1/movies/:id/history/:version/revert", app.requirePermission("movies:write", app.revertMovie))
+	handle(http.MethodGet, "/v1/trash/movies", app.requirePermission("movies:write", app.listDeletedMovies))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthentication)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetToken)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationToken)
+	handle(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCredits))
+	handle(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.createMovieCredit))
+	handle(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission("movies:write", app.deleteMovieCredit))
 
-	router.Handler(http.MethodGet, "/v1/metrics", expvar.Handler())
+	handle(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviews))
+	handle(http.MethodPost, "/v1/movies/:id/reviews", app.requireActivatedUser(app.createMovieReview))
+	handle(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requireActivatedUser(app.updateMovieReview))
+	handle(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requireActivatedUser(app.deleteMovieReview))
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	handle(http.MethodGet, "/v1/people", app.requirePermission("people:read", app.listPeople))
+	handle(http.MethodPost, "/v1/people", app.requirePermission("people:write", app.createPerson))
+	handle(http.MethodGet, "/v1/people/:id", app.requirePermission("people:read", app.showPerson))
+	handle(http.MethodPatch, "/v1/people/:id", app.requirePermission("people:write", app.updatePerson))
+	handle(http.MethodDelete, "/v1/people/:id", app.requirePermission("people:write", app.deletePerson))
+
+	handle(http.MethodPost, "/v1/users", app.registerUser)
+	handle(http.MethodPut, "/v1/users/activated", app.activateUser)
+	handle(http.MethodPut, "/v1/users/password", app.updateUserPassword)
+	handle(http.MethodPut, "/v1/users/unlocked", app.unlockAccount)
+
+	handle(http.MethodPost, "/v1/tokens/authentication", app.createAuthentication)
+	handle(http.MethodDelete, "/v1/tokens/authentication", app.requiredAuthenticatedUser(app.revokeAuthentication))
+	handle(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthentication)
+	handle(http.MethodDelete, "/v1/tokens", app.requiredAuthenticatedUser(app.revokeAllSessions))
+	handle(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetToken)
+	handle(http.MethodPost, "/v1/tokens/activation", app.createActivationToken)
+
+	handle(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsers))
+	handle(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUser))
+	handle(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:admin", app.updateUser))
+	handle(http.MethodDelete, "/v1/admin/users/:id", app.requirePermission("users:admin", app.deleteUser))
+	handle(http.MethodPut, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissions))
+	handle(http.MethodDelete, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.revokeUserPermissions))
+	handle(http.MethodPut, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.assignUserRoles))
+	handle(http.MethodDelete, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.unassignUserRoles))
+	handle(http.MethodGet, "/v1/admin/permissions", app.requirePermission("users:admin", app.listPermissions))
+	handle(http.MethodGet, "/v1/admin/log-level", app.requirePermission("users:admin", app.showLogLevel))
+	handle(http.MethodPut, "/v1/admin/log-level", app.requirePermission("users:admin", app.updateLogLevel))
+	handle(http.MethodGet, "/v1/admin/jobs", app.requirePermission("users:admin", app.listJobs))
+	handle(http.MethodGet, "/v1/admin/jobs/:id", app.requirePermission("users:admin", app.showJob))
+	handle(http.MethodPost, "/v1/admin/jobs/:id/retry", app.requirePermission("users:admin", app.retryJob))
+	handle(http.MethodGet, "/v1/admin/login-failures", app.requirePermission("users:admin", app.listLoginFailures))
+
+	handle(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:admin", app.listRoles))
+	handle(http.MethodPost, "/v1/admin/roles", app.requirePermission("users:admin", app.createRole))
+	handle(http.MethodGet, "/v1/admin/roles/:id", app.requirePermission("users:admin", app.showRole))
+	handle(http.MethodPatch, "/v1/admin/roles/:id", app.requirePermission("users:admin", app.updateRole))
+	handle(http.MethodDelete, "/v1/admin/roles/:id", app.requirePermission("users:admin", app.deleteRole))
+
+	handle(http.MethodGet, "/v1/metrics", expvar.Handler().ServeHTTP)
+	handle(http.MethodGet, "/metrics", app.metricsRegistry.Handler().ServeHTTP)
+
+	chain := app.traced("authenticate", app.authenticate(router))
+	chain = app.traced("rateLimit", app.rateLimit(chain))
+	chain = app.traced("enableCORS", app.enableCORS(chain))
+
+	// recoverPanic sits inside metrics, logRequest and trace, so that the 500
+	// a panic turns into is counted and logged like any other response,
+	// with its trace.
+	chain = app.traced("recoverPanic", app.recoverPanic(chain))
+
+	return app.requestID(app.trace(app.logRequest(app.metrics(chain))))
 }
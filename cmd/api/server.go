@@ -5,15 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/lighten/internal/jsonlog"
 )
 
 // serve intializes server and spins it up.
 func (app *application) serve() error {
+	// Every request context derives from baseCtx, so cancelling it aborts
+	// in-flight database queries that outlive the shutdown grace period.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
@@ -21,10 +29,38 @@ func (app *application) serve() error {
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
+	// Periodic jobs stop as soon as shutdown begins, before the server
+	// waits for background jobs to finish.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	go app.purgeTrash(jobsCtx)
+
+	// Queue workers stop claiming jobs once shutdown begins, and the server
+	// waits for the jobs they are running to finish.
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.jobs.Run(jobsCtx)
+	}()
+
 	shutdownErr := make(chan error)
 
+	// Background job to reload the configuration on SIGHUP
+	go func() {
+		reload := make(chan os.Signal, 1)
+
+		signal.Notify(reload, syscall.SIGHUP)
+		for range reload {
+			app.reloadConfig()
+		}
+	}()
+
 	// Background job to listen for any shutdown signal
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -32,30 +68,29 @@ func (app *application) serve() error {
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		s := <-quit
 
-		app.logger.PrintInfo("shutting down server", map[string]string{
-			"signal": s.String(),
-		})
+		app.logger.Info("shutting down server", jsonlog.String("signal", s.String()))
+
+		stopJobs()
 
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
 		err := server.Shutdown(ctx)
 		if err != nil {
+			cancelBase()
 			shutdownErr <- err
 		}
 
-		app.logger.PrintInfo("completing background tasks", map[string]string{
-			"addr": server.Addr,
-		})
+		app.logger.Info("completing background tasks", jsonlog.String("addr", server.Addr))
 
 		app.wg.Wait()
 		shutdownErr <- nil
 	}()
 
-	app.logger.PrintInfo("starting server", map[string]string{
-		"env":  app.config.env,
-		"addr": server.Addr,
-	})
+	app.logger.Info("starting server",
+		jsonlog.String("env", app.config.env),
+		jsonlog.String("addr", server.Addr),
+	)
 
 	err := server.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
@@ -67,9 +102,7 @@ func (app *application) serve() error {
 		return err
 	}
 
-	app.logger.PrintInfo("server stopped", map[string]string{
-		"addr": server.Addr,
-	})
+	app.logger.Info("server stopped", jsonlog.String("addr", server.Addr))
 
 	return nil
 }
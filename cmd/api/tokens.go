@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/jsonlog"
+	"github.com/lighten/internal/jwt"
 	"github.com/lighten/internal/validator"
 )
 
@@ -32,34 +36,236 @@ func (app *application) createAuthentication(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	// user stays nil when the email matches no user.
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Count the attempt against the email before checking the password,
+	// whether or not the email belongs to a user, so that neither
+	// concurrent guesses nor guesses at unknown emails escape the throttle.
+	// Attempts while it's backing off or locked are refused unchecked, so
+	// that guesses during the wait tell nothing.
+	attempt, err := app.models.LoginThrottles.Attempt(r.Context(), input.Email, app.lockoutPolicy())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if attempt.Wait > 0 {
+		reason := data.LoginThrottled
+		if attempt.Locked {
+			reason = data.LoginLocked
+		}
+
+		err = app.recordLoginFailure(r, input.Email, user, reason)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if attempt.Locked {
+			app.accountLockedResponse(w, r, attempt.Wait)
+		} else {
+			app.loginThrottledResponse(w, r, attempt.Wait)
+		}
+		return
+	}
+
+	var match bool
+
+	if user == nil {
+		data.SimulatePasswordCheck(input.Password)
+	} else {
+		match, err = user.Password.Matches(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !match {
+		reason := data.LoginWrongPassword
+		if user == nil {
+			reason = data.LoginUnknownEmail
+		}
+
+		err = app.recordLoginFailure(r, input.Email, user, reason)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// An unknown email locks like a known one, only without the
+		// email telling the user how to unlock it.
+		if attempt.LockedUntil != nil {
+			if user != nil {
+				err = app.sendLockoutEmail(r.Context(), user, *attempt.LockedUntil)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+
+			app.accountLockedResponse(w, r, time.Until(*attempt.LockedUntil))
+			return
+		}
+
+		app.invalidCredentialResponse(w, r)
+		return
+	}
+
+	// The attempt was counted as a failure up front; clear it.
+	err = app.models.LoginThrottles.Reset(r.Context(), user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	family, err := data.NewTokenFamily()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, refreshToken, err := app.newSessionTokens(r.Context(), user.ID, family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// newSessionTokens issues an authentication token and the refresh token
+// that can later replace it, both belonging to the login session family.
+func (app *application) newSessionTokens(ctx context.Context, userID int64, family string) (*data.Token, *data.Token, error) {
+	var (
+		token *data.Token
+		err   error
+	)
+
+	if app.jwtKeys != nil {
+		token, err = app.newJWT(ctx, userID, family)
+	} else {
+		token, err = app.models.Tokens.NewInFamily(ctx, userID, app.config.tokens.authenticationTTL, data.ScopeAuthentication, family)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := app.models.Tokens.NewInFamily(ctx, userID, app.config.tokens.refreshTTL, data.ScopeRefresh, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, refreshToken, nil
+}
+
+// refreshAuthentication maps to the 'POST /v1/tokens/refresh' endpoint. Each
+// refresh token can be exchanged once; presenting it again revokes the
+// whole session, since either the client or an attacker holds a copy.
+func (app *application) refreshAuthentication(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	consumed, err := app.models.Tokens.Consume(r.Context(), data.ScopeRefresh, input.RefreshToken)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			err = app.models.Tokens.DeleteFamily(r.Context(), consumed.Family)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if app.jwtDenylist != nil {
+				app.jwtDenylist.Revoke("sid:" + consumed.Family)
+			}
+
+			app.logger.Info("refresh token reused, session revoked", jsonlog.Int("user_id", consumed.UserID))
+			app.invalidAuthenticationTokenResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialResponse(w, r)
+			app.invalidAuthenticationTokenResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	match, err := user.Password.Matches(input.Password)
+	token, refreshToken, err := app.newSessionTokens(r.Context(), consumed.UserID, consumed.Family)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	if !match {
-		app.invalidCredentialResponse(w, r)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeAuthentication maps to the 'DELETE /v1/tokens/authentication' endpoint.
+// It logs out the current session: the bearer token and its refresh token.
+func (app *application) revokeAuthentication(w http.ResponseWriter, r *http.Request) {
+	token, ok := app.readBearerToken(r)
+	if !ok {
+		app.invalidAuthenticationTokenResponse(w, r)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	var err error
+	if app.jwtKeys != nil && jwt.LooksLikeJWT(token) {
+		err = app.revokeJWT(r.Context(), token)
+	} else {
+		err = app.models.Tokens.Revoke(r.Context(), data.ScopeAuthentication, token)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeAllSessions maps to the 'DELETE /v1/tokens' endpoint. It logs the
+// current user out everywhere.
+func (app *application) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.revokeUserSessions(r.Context(), user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"token": token}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions revoked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -83,7 +289,7 @@ func (app *application) createPasswordResetToken(w http.ResponseWriter, r *http.
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -100,23 +306,25 @@ func (app *application) createPasswordResetToken(w http.ResponseWriter, r *http.
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 45 *time.Minute, data.ScopePasswordReset)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Email user with their password reset token.
-	app.backgroundJob(func()  {
-		data := map[string]interface{}{
+	err = sendEmailJob.Enqueue(r.Context(), app.jobs, emailJob{
+		Recipient: user.Email,
+		Template:  "token_password_reset.tmpl",
+		Language:  user.Language,
+		Data: map[string]interface{}{
 			"passwordResetToken": token.Plaintext,
-		}
-
-		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
+		},
 	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	env := envelope{"messsage": "an email will be sent to you, containing the password reset instructions"}
 
@@ -145,7 +353,7 @@ func (app *application) createActivationToken(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -157,22 +365,24 @@ func (app *application) createActivationToken(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.backgroundJob(func()  {
-		data := map[string]interface{}{
+	err = sendEmailJob.Enqueue(r.Context(), app.jobs, emailJob{
+		Recipient: user.Email,
+		Template:  "token_activation.tmpl",
+		Language:  user.Language,
+		Data: map[string]interface{}{
 			"activationToken": token.Plaintext,
-		}
-
-		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
+		},
 	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	env := envelope{"message": "am email will be sent to you containing activation instructions"}
 
@@ -180,4 +390,20 @@ func (app *application) createActivationToken(w http.ResponseWriter, r *http.Req
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// revokeUserSessions invalidates every authentication and refresh token of a user.
+func (app *application) revokeUserSessions(ctx context.Context, userID int64) error {
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(ctx, scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if app.jwtDenylist != nil {
+		app.jwtDenylist.Revoke("user:" + strconv.FormatInt(userID, 10))
+	}
+
+	return nil
+}
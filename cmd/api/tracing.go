@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/lighten/internal/jsonlog"
+	"github.com/lighten/internal/tracing"
+)
+
+// trace starts the server span of a request, continuing the trace from
+// the traceparent header when the caller sent a valid one, and echoes
+// the span's traceparent back to the caller.
+func (app *application) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, err := tracing.ParseTraceparent(r.Header.Get("traceparent")); err == nil {
+			ctx = tracing.ContextWithRemoteParent(ctx, sc)
+		}
+
+		ctx, span := tracing.StartKind(ctx, r.Method, tracing.KindServer)
+		defer span.End()
+
+		w.Header().Set("traceparent", span.SpanContext().Traceparent())
+
+		r = r.WithContext(ctx)
+		m := httpsnoop.CaptureMetrics(next, w, r)
+
+		// Name the span after the route pattern, as the raw URL would
+		// split a single endpoint into one span name per resource.
+		if pattern := app.contextGetRequestInfo(r).pattern; pattern != "" {
+			span.SetName(r.Method + " " + pattern)
+			span.SetAttribute("http.route", pattern)
+		}
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("http.status_code", m.Code)
+	})
+}
+
+// traced wraps next in a span called name. Middleware spans cover the
+// handlers downstream of them too, so the time a middleware takes itself
+// is its span's duration minus that of its child span.
+func (app *application) traced(name string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), name)
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// newTracer configures span export to the OTLP/HTTP collector in the
+// configuration. Without a collector, spans are still created so that
+// trace IDs propagate and reach the logs, but they are never exported.
+func (app *application) newTracer() *tracing.Tracer {
+	if app.config.tracing.endpoint == "" {
+		return nil
+	}
+
+	tracer := tracing.NewTracer(app.config.tracing.serviceName, app.config.tracing.endpoint, app.config.tracing.sampleRatio)
+	tracer.OnError = func(err error) {
+		app.logger.Error(err, jsonlog.String("component", "tracing"))
+	}
+
+	app.logger.Info("exporting traces",
+		jsonlog.String("endpoint", app.config.tracing.endpoint),
+		jsonlog.Float("sample_ratio", app.config.tracing.sampleRatio),
+	)
+
+	return tracer
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/lighten/internal/data"
@@ -15,6 +16,7 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Language string `json:"language"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -23,10 +25,17 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Without an explicit choice, emails use the language the client asks
+	// for.
+	if input.Language == "" {
+		input.Language = app.preferredLanguage(r)
+	}
+
 	user := &data.User{
 		Name:      input.Name,
 		Email:     input.Email,
 		Activated: false,
+		Language:  strings.ToLower(input.Language),
 	}
 
 	err = user.Password.Set(input.Password)
@@ -42,7 +51,19 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// The welcome email is queued in the same transaction as the user, so
+	// that it is retried until it's sent, and never lost.
+	err = app.models.Users.Register(r.Context(), user, app.config.auth.defaultRole, 3*24*time.Hour, func(token *data.Token) (*data.Job, error) {
+		return sendEmailJob.Job(r.Context(), app.jobs, emailJob{
+			Recipient: user.Email,
+			Template:  "user_welcome.tmpl",
+			Language:  user.Language,
+			Data: map[string]interface{}{
+				"activationToken": token.Plaintext,
+				"userID":          user.ID,
+			},
+		})
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -54,31 +75,7 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Use backgroundJob helper to execute an anonymous function that sends the welcome
-	// email.
-	app.backgroundJob(func() {
-		data := map[string]interface{}{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-		}
-
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
-	})
+	app.jobs.Notify()
 
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
@@ -106,7 +103,7 @@ func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Retrieve the user associated with a token.
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -120,7 +117,7 @@ func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
 
 	user.Activated = true
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -131,7 +128,7 @@ func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -145,7 +142,7 @@ func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) updateUserPassword(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Password string `json:"password"`
+		Password       string `json:"password"`
 		TokenPlaintext string `json:"token"`
 	}
 
@@ -165,7 +162,7 @@ func (app *application) updateUserPassword(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -183,7 +180,7 @@ func (app *application) updateUserPassword(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -194,7 +191,14 @@ func (app *application) updateUserPassword(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// A new password also lifts any lock from failed logins.
+	err = app.models.LoginThrottles.Reset(r.Context(), user.Email)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -206,4 +210,4 @@ func (app *application) updateUserPassword(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
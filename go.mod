@@ -1,6 +1,6 @@
 module github.com/lighten
 
-go 1.19
+go 1.20
 
 require github.com/julienschmidt/httprouter v1.3.0
 
@@ -9,7 +9,7 @@ require github.com/lib/pq v1.10.0
 require github.com/tomasen/realip v0.0.0-20180522021738-f0c99a92ddce
 
 require (
-	github.com/felixge/httpsnoop v1.0.1
+	github.com/felixge/httpsnoop v1.0.4
 	gopkg.in/mail.v2 v2.3.1 // indirect
 )
 
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Cache stores opaque values under string keys for a limited time. Values
+// are bytes so that an out-of-process store, such as a Redis-compatible
+// server, can implement it as well as the in-process LRU.
+type Cache interface {
+	// Get returns the value stored under key and whether it was found.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key until ttl elapses.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the given keys. Missing keys are not an error.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Stats holds the counters kept by Metered.
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// Metered wraps a Cache and counts lookup hits and misses, whatever the
+// backend behind it.
+type Metered struct {
+	Cache
+	hits   int64
+	misses int64
+}
+
+// NewMetered returns c wrapped with hit and miss counters.
+func NewMetered(c Cache) *Metered {
+	return &Metered{Cache: c}
+}
+
+// Get looks key up in the wrapped cache and counts the outcome. Errors
+// count as misses, since the caller falls back to the source either way.
+func (m *Metered) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, ok, err := m.Cache.Get(ctx, key)
+	if ok && err == nil {
+		atomic.AddInt64(&m.hits, 1)
+	} else {
+		atomic.AddInt64(&m.misses, 1)
+	}
+
+	return value, ok, err
+}
+
+// Stats returns a snapshot of the counters.
+func (m *Metered) Stats() Stats {
+	return Stats{
+		Hits:   atomic.LoadInt64(&m.hits),
+		Misses: atomic.LoadInt64(&m.misses),
+	}
+}
@@ -1,6 +1,11 @@
 package data
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"math"
 	"strings"
 
@@ -13,6 +18,11 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// Cursor is the opaque keyset cursor provided by the client. When set,
+	// it takes precedence over Page.
+	Cursor string
+	// CursorKey signs and verifies cursors so clients cannot forge them.
+	CursorKey []byte
 }
 
 // sortColumn checks that the client-provided Sort field matches one of
@@ -45,6 +55,105 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the decoded form of the opaque pagination cursor. It records
+// the sort it was issued for and the sort key and id of the row at the
+// edge of the page, so the next query can resume right after it.
+type cursor struct {
+	Sort     string `json:"s"`
+	Value    string `json:"v"`
+	ID       int64  `json:"i"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// encodeCursor serializes and signs a cursor.
+func (f Filters) encodeCursor(c cursor) string {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+
+	mac := hmac.New(sha256.New, f.CursorKey)
+	mac.Write(payload)
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+// decodeCursor verifies and parses the client-provided cursor. It returns
+// nil when no cursor was provided.
+func (f Filters) decodeCursor() (*cursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+
+	payloadPart, sigPart, found := strings.Cut(f.Cursor, ".")
+	if !found {
+		return nil, errInvalidCursor
+	}
+
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(payloadPart)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	sig, err := enc.DecodeString(sigPart)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, f.CursorKey)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// keysetClause returns the comparison operators and orderings used to
+// fetch the rows following (or, for a backward cursor, preceding) c. Ties
+// on the sort column are always broken by ascending id.
+func (f Filters) keysetClause(c *cursor) (columnOp, idOp, columnOrder, idOrder string) {
+	ascending := f.sortDirection() == "ASC"
+	if c.Backward {
+		ascending = !ascending
+	}
+
+	columnOp, columnOrder = ">", "ASC"
+	if !ascending {
+		columnOp, columnOrder = "<", "DESC"
+	}
+
+	idOp, idOrder = ">", "ASC"
+	if c.Backward {
+		idOp, idOrder = "<", "DESC"
+	}
+
+	return columnOp, idOp, columnOrder, idOrder
+}
+
+// pageCursors builds the cursors pointing after the last row and before
+// the first row of a page.
+func (f Filters) pageCursors(first, last cursor, hasNext, hasPrev bool) (next, prev string) {
+	if hasNext {
+		last.Sort, last.Backward = f.Sort, false
+		next = f.encodeCursor(last)
+	}
+	if hasPrev {
+		first.Sort, first.Backward = f.Sort, true
+		prev = f.encodeCursor(first)
+	}
+
+	return next, prev
+}
+
 // ValidateFilters validates the query_string for abnormalities
 func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
@@ -54,16 +163,25 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	c, err := f.decodeCursor()
+	if err != nil {
+		v.AddError("cursor", "must be a valid cursor")
+	} else if c != nil {
+		v.Check(c.Sort == f.Sort, "cursor", "does not match the sort parameter")
+	}
 }
 
 // Provides extra info about the filtered, sorted and paginated
 // info returned on 'GET /v1/movies?<query_string>'
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 // calcMetadata calculates and return pagination info
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lighten/internal/validator"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := []byte("cursor-secret")
+
+	tests := []struct {
+		name   string
+		cursor cursor
+	}{
+		{"forward", cursor{Sort: "title", Value: "Moana", ID: 12}},
+		{"backward", cursor{Sort: "-year", Value: "2016", ID: 3, Backward: true}},
+		{"empty value", cursor{Sort: "id", ID: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := Filters{CursorKey: key}.encodeCursor(tt.cursor)
+
+			got, err := Filters{Cursor: encoded, CursorKey: key}.decodeCursor()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.cursor) {
+				t.Errorf("got %+v; want %+v", *got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	key := []byte("cursor-secret")
+	valid := Filters{CursorKey: key}.encodeCursor(cursor{Sort: "id", Value: "5", ID: 5})
+	payload, sig, _ := strings.Cut(valid, ".")
+
+	enc := base64.RawURLEncoding
+	forged := enc.EncodeToString([]byte(`{"s":"id","v":"1","i":1}`)) + "." + sig
+
+	tests := []struct {
+		name   string
+		cursor string
+		key    []byte
+	}{
+		{"wrong key", valid, []byte("another-secret")},
+		{"forged payload", forged, key},
+		{"missing signature", payload, key},
+		{"truncated signature", payload + "." + sig[:len(sig)-2], key},
+		{"bad payload encoding", "!!!." + sig, key},
+		{"bad signature encoding", payload + ".!!!", key},
+		{"not json", signedPayload(key, "not json"), key},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Filters{Cursor: tt.cursor, CursorKey: tt.key}.decodeCursor()
+			if err != errInvalidCursor {
+				t.Errorf("got %+v, %v; want errInvalidCursor", c, err)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorEmpty(t *testing.T) {
+	c, err := Filters{CursorKey: []byte("cursor-secret")}.decodeCursor()
+	if c != nil || err != nil {
+		t.Errorf("got %+v, %v; want nil, nil", c, err)
+	}
+}
+
+func TestValidateFiltersCursor(t *testing.T) {
+	key := []byte("cursor-secret")
+	safelist := []string{"id", "title", "-id", "-title"}
+	byTitle := Filters{CursorKey: key}.encodeCursor(cursor{Sort: "title", Value: "Moana", ID: 12})
+
+	tests := []struct {
+		name    string
+		sort    string
+		cursor  string
+		wantErr string
+	}{
+		{"no cursor", "title", "", ""},
+		{"matching sort", "title", byTitle, ""},
+		{"other sort", "-title", byTitle, "does not match the sort parameter"},
+		{"tampered", "title", byTitle + "A", "must be a valid cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateFilters(v, Filters{
+				Page:         1,
+				PageSize:     20,
+				Sort:         tt.sort,
+				SortSafelist: safelist,
+				Cursor:       tt.cursor,
+				CursorKey:    key,
+			})
+
+			if got := v.Errors["cursor"]; got != tt.wantErr {
+				t.Errorf("got cursor error %q; want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+// signedPayload signs an arbitrary payload the way encodeCursor does.
+func signedPayload(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(payload)) + "." + enc.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,265 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Job states. A job is pending until a worker claims it, running while the
+// worker's lease lasts, and dead once it has failed for the last time.
+// Jobs are deleted when they succeed.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDead    = "dead"
+)
+
+// Job is a unit of work queued in the database.
+type Job struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Kind      string    `json:"kind"`
+	// Payload isn't exposed through the API, as it can hold secrets such
+	// as the tokens sent by email.
+	Payload     json.RawMessage `json:"-"`
+	State       string          `json:"state"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	// LockedUntil is when the lease of the worker running the job ends.
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	// Traceparent and RequestID identify the trace and the request that
+	// enqueued the job, if any, so that its attempts can continue them.
+	Traceparent string `json:"traceparent,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+}
+
+// JobModel wraps the sql.DB connection pool.
+type JobModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+const jobColumns = `id, created_at, updated_at, kind, payload, state, attempts, max_attempts, run_at, locked_until, last_error, traceparent, request_id`
+
+func scanJob(scan func(dest ...interface{}) error, job *Job, extra ...interface{}) error {
+	dest := append(extra,
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Kind,
+		(*[]byte)(&job.Payload),
+		&job.State,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LockedUntil,
+		&job.LastError,
+		&job.Traceparent,
+		&job.RequestID,
+	)
+	return scan(dest...)
+}
+
+// Enqueue inserts a pending job, to run no earlier than job.RunAt, or
+// straight away if it is zero.
+func (m JobModel) Enqueue(ctx context.Context, job *Job) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return insertJob(ctx, m.DB, job)
+}
+
+// insertJob inserts a pending job through q, so that it can be enqueued as
+// part of a larger transaction.
+func insertJob(ctx context.Context, q querier, job *Job) error {
+	stmt := `
+	INSERT INTO jobs (kind, payload, max_attempts, run_at, traceparent, request_id)
+	VALUES ($1, $2, $3, COALESCE($4, now()), $5, $6)
+	RETURNING ` + jobColumns
+
+	var runAt *time.Time
+	if !job.RunAt.IsZero() {
+		runAt = &job.RunAt
+	}
+
+	return scanJob(q.QueryRowContext(ctx, stmt, job.Kind, []byte(job.Payload), job.MaxAttempts, runAt, job.Traceparent, job.RequestID).Scan, job)
+}
+
+// Claim marks the next due job of one of the given kinds as running for
+// the length of lease, counting an attempt, and returns it. Jobs whose
+// lease ran out, because their worker died, are claimed again. Concurrent
+// claims skip each other's rows rather than wait for them. Claim returns
+// ErrRecordNotFound when no job is due.
+func (m JobModel) Claim(ctx context.Context, kinds []string, lease time.Duration) (*Job, error) {
+	stmt := `
+	WITH next AS (
+		SELECT id FROM jobs
+		WHERE kind = ANY($1)
+		AND ((state = 'pending' AND run_at <= now()) OR (state = 'running' AND locked_until < now()))
+		ORDER BY run_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE jobs
+	SET state = 'running', attempts = attempts + 1, locked_until = now() + make_interval(secs => $2), updated_at = now()
+	FROM next
+	WHERE jobs.id = next.id
+	RETURNING jobs.id, jobs.created_at, jobs.updated_at, jobs.kind, jobs.payload, jobs.state,
+		jobs.attempts, jobs.max_attempts, jobs.run_at, jobs.locked_until, jobs.last_error,
+		jobs.traceparent, jobs.request_id`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var job Job
+
+	err := scanJob(m.DB.QueryRowContext(ctx, stmt, pq.Array(kinds), lease.Seconds()).Scan, &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// Complete deletes a job that ran successfully.
+func (m JobModel) Complete(ctx context.Context, id int64) error {
+	stmt := `DELETE FROM jobs WHERE id = $1 AND state = 'running'`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	return err
+}
+
+// Reschedule returns a running job that failed to pending, to be tried
+// again at runAt.
+func (m JobModel) Reschedule(ctx context.Context, id int64, runAt time.Time, lastError string) error {
+	stmt := `
+	UPDATE jobs
+	SET state = 'pending', run_at = $2, locked_until = NULL, last_error = $3, updated_at = now()
+	WHERE id = $1 AND state = 'running'`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, id, runAt, lastError)
+	return err
+}
+
+// Bury marks a running job dead, leaving it for an administrator to retry.
+func (m JobModel) Bury(ctx context.Context, id int64, lastError string) error {
+	stmt := `
+	UPDATE jobs
+	SET state = 'dead', locked_until = NULL, last_error = $2, updated_at = now()
+	WHERE id = $1 AND state = 'running'`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, id, lastError)
+	return err
+}
+
+// Retry makes a dead job pending again with a fresh set of attempts. It
+// fails with ErrRecordNotFound unless the job is dead.
+func (m JobModel) Retry(ctx context.Context, id int64) (*Job, error) {
+	stmt := `
+	UPDATE jobs
+	SET state = 'pending', attempts = 0, run_at = now(), updated_at = now()
+	WHERE id = $1 AND state = 'dead'
+	RETURNING ` + jobColumns
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var job Job
+
+	err := scanJob(m.DB.QueryRowContext(ctx, stmt, id).Scan, &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// Get fetches a specific job.
+func (m JobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	stmt := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var job Job
+
+	err := scanJob(m.DB.QueryRowContext(ctx, stmt, id).Scan, &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// GetAll returns a page of the jobs, optionally only those in one state or
+// of one kind.
+func (m JobModel) GetAll(ctx context.Context, state, kind string, filters Filters) ([]*Job, Metadata, error) {
+	stmt := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s
+	FROM jobs
+	WHERE (state = $1 OR $1 = '') AND (kind = $2 OR $2 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4`, jobColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, state, kind, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	jobs := []*Job{}
+
+	for rows.Next() {
+		var job Job
+		if err := scanJob(rows.Scan, &job, &totalRecords); err != nil {
+			return nil, Metadata{}, err
+		}
+		jobs = append(jobs, &job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return jobs, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Reasons a login failed, as recorded in the audit log.
+const (
+	LoginUnknownEmail  = "unknown_email"
+	LoginWrongPassword = "wrong_password"
+	LoginThrottled     = "throttled"
+	LoginLocked        = "locked"
+)
+
+// LoginThrottle tracks the consecutive failed logins with an email, which
+// need not belong to any user.
+type LoginThrottle struct {
+	Email    string
+	Failures int
+	// RetryAfter is when a password may next be tried, after backing off
+	// from the last failure.
+	RetryAfter *time.Time
+	// LockedUntil is when a locked account unlocks by itself.
+	LockedUntil *time.Time
+	// UpdatedAt is when the last attempt was made.
+	UpdatedAt time.Time
+}
+
+// Wait returns how long to wait before trying a password again, and
+// whether that is because the account is locked.
+func (t *LoginThrottle) Wait(now time.Time) (time.Duration, bool) {
+	if t.LockedUntil != nil && now.Before(*t.LockedUntil) {
+		return t.LockedUntil.Sub(now), true
+	}
+	if t.RetryAfter != nil && now.Before(*t.RetryAfter) {
+		return t.RetryAfter.Sub(now), false
+	}
+	return 0, false
+}
+
+// LockoutPolicy decides how failed logins are throttled.
+type LockoutPolicy struct {
+	// FreeAttempts is the number of failures allowed before each further
+	// attempt must wait, Backoff after the first and twice as long after
+	// each one after that, up to MaxBackoff.
+	FreeAttempts int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	// Threshold is the number of consecutive failures that locks the
+	// account for Duration.
+	Threshold int
+	Duration  time.Duration
+}
+
+// apply records a failure at now, reporting whether it locked the account.
+// Locking starts the count of failures over, so that a user whose lock ran
+// out isn't held back by the failures that caused it.
+func (p LockoutPolicy) apply(t *LoginThrottle, now time.Time) bool {
+	if t.LockedUntil != nil && !now.Before(*t.LockedUntil) {
+		t.LockedUntil = nil
+	}
+
+	t.Failures++
+	t.RetryAfter = nil
+
+	if t.Failures >= p.Threshold {
+		lockedUntil := now.Add(p.Duration)
+		t.LockedUntil = &lockedUntil
+		t.Failures = 0
+		return true
+	}
+
+	if t.Failures > p.FreeAttempts {
+		delay := p.Backoff
+		for i := p.FreeAttempts + 1; i < t.Failures && delay < p.MaxBackoff; i++ {
+			delay *= 2
+		}
+		if delay > p.MaxBackoff {
+			delay = p.MaxBackoff
+		}
+
+		retryAfter := now.Add(delay)
+		t.RetryAfter = &retryAfter
+	}
+
+	return false
+}
+
+// LoginThrottleModel wraps the sql.DB connection pool.
+type LoginThrottleModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// LoginAttempt is the outcome of LoginThrottleStore.Attempt.
+type LoginAttempt struct {
+	// Wait is how long to wait before trying again when the attempt was
+	// refused, and Locked whether it was refused because the account is
+	// locked.
+	Wait   time.Duration
+	Locked bool
+	// LockedUntil is set when the attempt locked the account, unless its
+	// password turns out to be right.
+	LockedUntil *time.Time
+}
+
+// Attempt records a login attempt with email under policy, before its
+// password is checked. Unless the email must wait, in which case the
+// attempt is refused, it counts as a failure until Reset clears it after a
+// successful login. Holding the email's row locked while both checking and
+// counting means that concurrent guesses can't all slip past the throttle.
+func (m LoginThrottleModel) Attempt(ctx context.Context, email string, policy LockoutPolicy) (*LoginAttempt, error) {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the email's row, creating it if needed.
+	stmt := `
+	INSERT INTO login_throttles (email) VALUES ($1)
+	ON CONFLICT (email) DO UPDATE SET updated_at = NOW()
+	RETURNING failures, retry_after, locked_until, updated_at`
+
+	throttle := LoginThrottle{Email: email}
+
+	err = tx.QueryRowContext(ctx, stmt, email).Scan(&throttle.Failures, &throttle.RetryAfter, &throttle.LockedUntil, &throttle.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var attempt LoginAttempt
+
+	if attempt.Wait, attempt.Locked = throttle.Wait(now); attempt.Wait > 0 {
+		return &attempt, tx.Commit()
+	}
+
+	if policy.apply(&throttle, now) {
+		attempt.LockedUntil = throttle.LockedUntil
+	}
+
+	stmt = `
+	UPDATE login_throttles
+	SET failures = $2, retry_after = $3, locked_until = $4, updated_at = NOW()
+	WHERE email = $1`
+
+	_, err = tx.ExecContext(ctx, stmt, email, throttle.Failures, throttle.RetryAfter, throttle.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// Purge deletes the throttles that impose no wait and have seen no attempt
+// since before cutoff, forgetting their failures, and returns how many
+// went. Without it, every email ever tried would keep a row.
+func (m LoginThrottleModel) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	stmt := `
+	DELETE FROM login_throttles
+	WHERE updated_at < $1
+	AND (retry_after IS NULL OR retry_after < NOW())
+	AND (locked_until IS NULL OR locked_until < NOW())`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	resp, err := m.DB.ExecContext(ctx, stmt, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.RowsAffected()
+}
+
+// Reset clears the failures with an email and any lock, after a successful
+// login or an unlock.
+func (m LoginThrottleModel) Reset(ctx context.Context, email string) error {
+	stmt := `DELETE FROM login_throttles WHERE email = $1`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, email)
+	return err
+}
+
+// LoginFailure is an audit log entry for a failed login. UserID is nil
+// when the email matched no user.
+type LoginFailure struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    *int64    `json:"user_id"`
+	Email     string    `json:"email"`
+	IPAddress string    `json:"ip_address"`
+	Reason    string    `json:"reason"`
+}
+
+// LoginFailureModel wraps the sql.DB connection pool.
+type LoginFailureModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert adds an entry to the audit log.
+func (m LoginFailureModel) Insert(ctx context.Context, failure *LoginFailure) error {
+	stmt := `
+	INSERT INTO login_failures (user_id, email, ip_address, reason)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	args := []interface{}{failure.UserID, failure.Email, failure.IPAddress, failure.Reason}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, stmt, args...).Scan(&failure.ID, &failure.CreatedAt)
+}
+
+// GetAll returns a page of the audit log, optionally only the entries for
+// an email address or a user.
+func (m LoginFailureModel) GetAll(ctx context.Context, email string, userID int64, filters Filters) ([]*LoginFailure, Metadata, error) {
+	stmt := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, user_id, email, ip_address, reason
+	FROM login_failures
+	WHERE (email = $1 OR $1 = '') AND (user_id = $2 OR $2 = 0)
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{email, userID, filters.limit(), filters.offset()}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	failures := []*LoginFailure{}
+
+	for rows.Next() {
+		var failure LoginFailure
+		err := rows.Scan(
+			&totalRecords,
+			&failure.ID,
+			&failure.CreatedAt,
+			&failure.UserID,
+			&failure.Email,
+			&failure.IPAddress,
+			&failure.Reason,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		failures = append(failures, &failure)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return failures, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lighten/internal/cache"
+)
+
+// LookupCache caches the lookups made on every authenticated request:
+// token to user, and user to permissions. Models invalidate the affected
+// keys whenever they change the records behind them. A nil *LookupCache
+// disables caching.
+type LookupCache struct {
+	Cache cache.Cache
+	TTL   time.Duration
+}
+
+func tokenCacheKey(scope string, hash []byte) string {
+	return "token:" + scope + ":" + hex.EncodeToString(hash)
+}
+
+func userCacheKey(id int64) string {
+	return fmt.Sprintf("user:%d", id)
+}
+
+func permissionsCacheKey(userID int64) string {
+	return fmt.Sprintf("permissions:%d", userID)
+}
+
+// cachedToken is what a token key resolves to.
+type cachedToken struct {
+	UserID int64     `json:"user_id"`
+	Expiry time.Time `json:"expiry"`
+}
+
+// cachedUser mirrors User. The password hash is left out, so that it is
+// never copied to a shared cache; authentication doesn't need it.
+type cachedUser struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Activated bool      `json:"activated"`
+	Language  string    `json:"language"`
+	Version   int       `json:"version"`
+}
+
+// get decodes the value stored under key into dst. Any cache failure is
+// reported as a miss, so lookups fall back to the database.
+func (c *LookupCache) get(ctx context.Context, key string, dst interface{}) bool {
+	if c == nil {
+		return false
+	}
+
+	value, ok, err := c.Cache.Get(ctx, key)
+	if err != nil || !ok {
+		return false
+	}
+
+	return json.Unmarshal(value, dst) == nil
+}
+
+// set stores v under key for the configured TTL, or until expiry if that
+// comes first. Failing to fill the cache only costs a later miss.
+func (c *LookupCache) set(ctx context.Context, key string, v interface{}, expiry time.Time) {
+	if c == nil {
+		return
+	}
+
+	ttl := c.TTL
+	if !expiry.IsZero() {
+		if untilExpiry := time.Until(expiry); untilExpiry < ttl {
+			ttl = untilExpiry
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	_ = c.Cache.Set(ctx, key, value, ttl)
+}
+
+// invalidate removes keys whose records have changed. Unlike lookups,
+// failures are returned, since a stale entry could keep granting access.
+func (c *LookupCache) invalidate(ctx context.Context, keys ...string) error {
+	if c == nil || len(keys) == 0 {
+		return nil
+	}
+
+	return c.Cache.Delete(ctx, keys...)
+}
@@ -0,0 +1,2239 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryStore holds every record for the in-memory backend. A single
+// mutex guards all tables so that lookups spanning several of them, like
+// GetForToken, see a consistent view.
+type memoryStore struct {
+	mu sync.RWMutex
+
+	movies          map[int64]*Movie
+	lastMovieID     int64
+	revisions       map[int64][]*MovieRevision
+	lastRevisionID  int64
+	users           map[int64]*User
+	lastUserID      int64
+	tokens          map[string]*Token
+	permissions     []string
+	userPermissions map[int64]map[string]bool
+	people          map[int64]*Person
+	lastPersonID    int64
+	credits         map[int64]*Credit
+	lastCreditID    int64
+	reviews         map[int64]*Review
+	lastReviewID    int64
+	roles           map[int64]*Role
+	lastRoleID      int64
+	userRoles       map[int64]map[int64]bool
+	jobs            map[int64]*Job
+	lastJobID       int64
+	loginThrottles  map[string]*LoginThrottle
+	loginFailures   []*LoginFailure
+}
+
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{
+		movies:          make(map[int64]*Movie),
+		revisions:       make(map[int64][]*MovieRevision),
+		users:           make(map[int64]*User),
+		tokens:          make(map[string]*Token),
+		permissions:     []string{"movies:read", "movies:write", "people:read", "people:write", "users:admin"},
+		userPermissions: make(map[int64]map[string]bool),
+		people:          make(map[int64]*Person),
+		credits:         make(map[int64]*Credit),
+		reviews:         make(map[int64]*Review),
+		roles:           make(map[int64]*Role),
+		userRoles:       make(map[int64]map[int64]bool),
+		jobs:            make(map[int64]*Job),
+		loginThrottles:  make(map[string]*LoginThrottle),
+	}
+
+	// Seed the same roles as the roles migration.
+	for _, role := range []*Role{
+		{Name: "viewer", Description: "Read access to movies and people", Permissions: Permissions{"movies:read", "people:read"}},
+		{Name: "editor", Description: "Read and write access to movies and people", Permissions: Permissions{"movies:read", "movies:write", "people:read", "people:write"}},
+		{Name: "admin", Description: "Full access, including user administration", Permissions: append(Permissions{}, s.permissions...)},
+	} {
+		s.lastRoleID++
+		role.ID = s.lastRoleID
+		role.Version = 1
+		s.roles[role.ID] = role
+	}
+
+	return s
+}
+
+// copyMovie returns a deep copy so callers never share state with the store.
+func copyMovie(movie *Movie) *Movie {
+	cp := *movie
+	if movie.Genres != nil {
+		cp.Genres = append([]string{}, movie.Genres...)
+	}
+	if movie.DeletedAt != nil {
+		deletedAt := *movie.DeletedAt
+		cp.DeletedAt = &deletedAt
+	}
+	return &cp
+}
+
+func copyRevision(rev *MovieRevision) *MovieRevision {
+	cp := *rev
+	cp.ChangedFields = append([]string{}, rev.ChangedFields...)
+	cp.Movie.Genres = append([]string{}, rev.Movie.Genres...)
+	if rev.UserID != nil {
+		userID := *rev.UserID
+		cp.UserID = &userID
+	}
+	return &cp
+}
+
+// addRevision stores a revision of a movie. The caller must hold the
+// store's lock.
+func (s *memoryStore) addRevision(rev *MovieRevision) {
+	s.lastRevisionID++
+	rev.ID = s.lastRevisionID
+	rev.CreatedAt = time.Now().Truncate(time.Second)
+	s.revisions[rev.MovieID] = append(s.revisions[rev.MovieID], rev)
+}
+
+func copyUser(user *User) *User {
+	cp := *user
+	cp.Password.plaintext = nil
+	return &cp
+}
+
+// MemoryMovieModel is the in-memory implementation of MovieStore.
+type MemoryMovieModel struct {
+	store *memoryStore
+}
+
+// Insert adds a new movie record to the store.
+func (m MemoryMovieModel) Insert(ctx context.Context, movie *Movie, userID int64) error {
+	return m.InsertMany(ctx, []*Movie{movie}, userID)
+}
+
+// InsertMany adds several movie records to the store at once.
+func (m MemoryMovieModel) InsertMany(ctx context.Context, movies []*Movie, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, movie := range movies {
+		m.store.lastMovieID++
+		movie.ID = m.store.lastMovieID
+		movie.CreatedAt = time.Now().Truncate(time.Second)
+		movie.Version = 1
+		movie.AverageRating, movie.RatingCount = 0, 0
+
+		m.store.movies[movie.ID] = copyMovie(movie)
+		m.store.addRevision(newRevision(movie, RevisionCreate, MovieSnapshot{}, userID))
+	}
+
+	return nil
+}
+
+// Get fetches a specific movie record with the id
+func (m MemoryMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	movie, ok := m.store.movies[id]
+	if !ok || movie.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyMovie(movie), nil
+}
+
+// GetAll gets all the movie records matched by the title and genres,
+// mirroring the full-text and array containment filters of MovieModel.
+func (m MemoryMovieModel) GetAll(ctx context.Context, title string, genres []string, personID int64, director string, filters Filters) ([]*Movie, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	c, err := filters.decodeCursor()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+	terms, directorTerms := lexemes(title), lexemes(director)
+
+	m.store.mu.RLock()
+	matched := []*Movie{}
+	for _, movie := range m.store.movies {
+		if movie.DeletedAt == nil && matchesLexemes(movie.Title, terms) && containsAll(movie.Genres, genres) &&
+			m.store.matchesCredits(movie.ID, personID, directorTerms) {
+			matched = append(matched, copyMovie(movie))
+		}
+	}
+	m.store.mu.RUnlock()
+
+	less := func(a, b *Movie) bool {
+		if c := compareMovies(a, b, column); c != 0 {
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return a.ID < b.ID
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return less(matched[i], matched[j])
+	})
+
+	if c != nil {
+		pivot, err := cursorPivot(c, column)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// Collect up to one row more than a page, walking away from the
+		// pivot in the direction of the cursor, as the keyset query does.
+		page := []*Movie{}
+		if c.Backward {
+			for i := len(matched) - 1; i >= 0 && len(page) <= filters.limit(); i-- {
+				if less(matched[i], pivot) {
+					page = append(page, matched[i])
+				}
+			}
+		} else {
+			for i := 0; i < len(matched) && len(page) <= filters.limit(); i++ {
+				if less(pivot, matched[i]) {
+					page = append(page, matched[i])
+				}
+			}
+		}
+
+		movies, metadata := keysetPage(page, filters, c)
+		return movies, metadata, nil
+	}
+
+	totalRecords := len(matched)
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	movies := matched[start:end]
+	if len(movies) == 0 {
+		return movies, Metadata{}, nil
+	}
+
+	metadata := calcMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata.NextCursor, metadata.PrevCursor = filters.pageCursors(
+		movieCursor(movies[0], column),
+		movieCursor(movies[len(movies)-1], column),
+		filters.Page < metadata.LastPage,
+		filters.Page > 1,
+	)
+
+	return movies, metadata, nil
+}
+
+// cursorPivot rebuilds the edge row a cursor points at, so it can be
+// compared against stored movies.
+func cursorPivot(c *cursor, column string) (*Movie, error) {
+	pivot := &Movie{ID: c.ID}
+
+	switch column {
+	case "title":
+		pivot.Title = c.Value
+	case "average_rating":
+		f, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		pivot.AverageRating = f
+	case "year", "runtime", "rating_count", "id":
+		n, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		pivot.Year, pivot.Runtime, pivot.RatingCount = int32(n), Runtime(n), int32(n)
+		if column == "id" {
+			pivot.ID = n
+		}
+	}
+
+	return pivot, nil
+}
+
+// Update updates a record with the movie arg passed, failing with
+// ErrEditConflict when the stored version has moved on.
+func (m MemoryMovieModel) Update(ctx context.Context, movie *Movie, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	current, ok := m.store.movies[movie.ID]
+	if !ok || current.DeletedAt != nil || current.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	m.store.updateMovie(current, movie, RevisionUpdate, userID)
+
+	return nil
+}
+
+// updateMovie replaces current with movie as its next version and records
+// the change as a revision, which it returns. The caller must hold the
+// store's lock.
+func (s *memoryStore) updateMovie(current, movie *Movie, action string, userID int64) *MovieRevision {
+	movie.Version++
+	updated := copyMovie(movie)
+	updated.CreatedAt = current.CreatedAt
+	updated.AverageRating, updated.RatingCount = current.AverageRating, current.RatingCount
+	s.movies[movie.ID] = updated
+
+	rev := newRevision(updated, action, snapshotMovie(current), userID)
+	s.addRevision(rev)
+
+	return rev
+}
+
+// Revert sets a movie's fields back to those it had at an earlier version,
+// as a new version.
+func (m MemoryMovieModel) Revert(ctx context.Context, movie *Movie, version int32, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	current, ok := m.store.movies[movie.ID]
+	if !ok || current.DeletedAt != nil || current.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	var target *MovieRevision
+	for _, rev := range m.store.revisions[movie.ID] {
+		if rev.Version == version {
+			target = rev
+		}
+	}
+	if target == nil {
+		return ErrRecordNotFound
+	}
+
+	movie.Title, movie.Year, movie.Runtime = target.Movie.Title, target.Movie.Year, target.Movie.Runtime
+	movie.Genres = append([]string{}, target.Movie.Genres...)
+
+	m.store.updateMovie(current, movie, RevisionRevert, userID).RevertedFrom = version
+
+	return nil
+}
+
+// setDeleted moves a movie into or out of the trash as a new version. When
+// version is not 0 the movie must still be at that version.
+func (m MemoryMovieModel) setDeleted(ctx context.Context, id int64, deleted bool, version int32, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movie, ok := m.store.movies[id]
+	switch {
+	case (!ok || (movie.DeletedAt != nil) == deleted || movie.Version != version) && version != 0:
+		return ErrEditConflict
+	case !ok || (movie.DeletedAt != nil) == deleted:
+		return ErrRecordNotFound
+	}
+	prev := snapshotMovie(movie)
+
+	action := RevisionRestore
+	movie.DeletedAt = nil
+	if deleted {
+		action = RevisionDelete
+		deletedAt := time.Now().Truncate(time.Second)
+		movie.DeletedAt = &deletedAt
+	}
+	movie.Version++
+
+	m.store.addRevision(newRevision(movie, action, prev, userID))
+
+	return nil
+}
+
+// Delete moves a specific movie record to the trash, conditionally on a
+// non-zero version.
+func (m MemoryMovieModel) Delete(ctx context.Context, id int64, version int32, userID int64) error {
+	return m.setDeleted(ctx, id, true, version, userID)
+}
+
+// Restore takes a specific movie record out of the trash.
+func (m MemoryMovieModel) Restore(ctx context.Context, id, userID int64) error {
+	return m.setDeleted(ctx, id, false, 0, userID)
+}
+
+// GetDeleted returns a page of the movies in the trash, most recently
+// deleted first.
+func (m MemoryMovieModel) GetDeleted(ctx context.Context, filters Filters) ([]*Movie, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	m.store.mu.RLock()
+	deleted := []*Movie{}
+	for _, movie := range m.store.movies {
+		if movie.DeletedAt != nil {
+			deleted = append(deleted, copyMovie(movie))
+		}
+	}
+	m.store.mu.RUnlock()
+
+	sort.Slice(deleted, func(i, j int) bool {
+		if !deleted[i].DeletedAt.Equal(*deleted[j].DeletedAt) {
+			return deleted[i].DeletedAt.After(*deleted[j].DeletedAt)
+		}
+		return deleted[i].ID > deleted[j].ID
+	})
+
+	totalRecords := len(deleted)
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return deleted[start:end], calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Purge permanently deletes the movies that went into the trash before
+// cutoff, along with their credits and reviews. Their revisions are kept,
+// closed by a final purge revision.
+func (m MemoryMovieModel) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var purged int64
+
+	for id, movie := range m.store.movies {
+		if movie.DeletedAt == nil || !movie.DeletedAt.Before(cutoff) {
+			continue
+		}
+		delete(m.store.movies, id)
+		purged++
+
+		prev := snapshotMovie(movie)
+		movie.Version++
+		m.store.addRevision(newRevision(movie, RevisionPurge, prev, 0))
+
+		for creditID, credit := range m.store.credits {
+			if credit.MovieID == id {
+				delete(m.store.credits, creditID)
+			}
+		}
+		for reviewID, review := range m.store.reviews {
+			if review.MovieID == id {
+				delete(m.store.reviews, reviewID)
+			}
+		}
+	}
+
+	return purged, nil
+}
+
+// MemoryMovieRevisionModel is the in-memory implementation of
+// MovieRevisionStore.
+type MemoryMovieRevisionModel struct {
+	store *memoryStore
+}
+
+// GetAllForMovie returns a page of a movie's revisions, newest first.
+func (m MemoryMovieRevisionModel) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	m.store.mu.RLock()
+	revisions := []*MovieRevision{}
+	stored := m.store.revisions[movieID]
+	for i := len(stored) - 1; i >= 0; i-- {
+		revisions = append(revisions, copyRevision(stored[i]))
+	}
+	m.store.mu.RUnlock()
+
+	totalRecords := len(revisions)
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return revisions[start:end], calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Get fetches the revision of a movie at a specific version.
+func (m MemoryMovieRevisionModel) Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	for _, rev := range m.store.revisions[movieID] {
+		if rev.Version == version {
+			return copyRevision(rev), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+// matchesCredits reports whether a movie credits the person, when one is
+// given, and has a director whose name matches directorTerms. The caller
+// must hold the store's lock.
+func (s *memoryStore) matchesCredits(movieID, personID int64, directorTerms []string) bool {
+	personFound, directorFound := personID == 0, len(directorTerms) == 0
+
+	for _, credit := range s.credits {
+		if credit.MovieID != movieID {
+			continue
+		}
+		if credit.PersonID == personID {
+			personFound = true
+		}
+		if credit.Role == RoleDirector && !directorFound {
+			if person, ok := s.people[credit.PersonID]; ok && matchesLexemes(person.Name, directorTerms) {
+				directorFound = true
+			}
+		}
+	}
+
+	return personFound && directorFound
+}
+
+// compareMovies orders two movies by one of the sortable columns.
+func compareMovies(a, b *Movie, column string) int {
+	switch column {
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "year":
+		return int(a.Year) - int(b.Year)
+	case "runtime":
+		return int(a.Runtime) - int(b.Runtime)
+	case "average_rating":
+		switch {
+		case a.AverageRating < b.AverageRating:
+			return -1
+		case a.AverageRating > b.AverageRating:
+			return 1
+		}
+		return 0
+	case "rating_count":
+		return int(a.RatingCount) - int(b.RatingCount)
+	default:
+		switch {
+		case a.ID < b.ID:
+			return -1
+		case a.ID > b.ID:
+			return 1
+		}
+		return 0
+	}
+}
+
+// lexemes splits text into lower-cased words, approximating the 'simple'
+// text search configuration used by PostgreSQL.
+func lexemes(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesLexemes reports whether every term appears as a word in text.
+func matchesLexemes(text string, terms []string) bool {
+	words := make(map[string]bool)
+	for _, word := range lexemes(text) {
+		words[word] = true
+	}
+
+	for _, term := range terms {
+		if !words[term] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsAll reports whether values holds every entry of subset.
+func containsAll(values, subset []string) bool {
+	for _, s := range subset {
+		found := false
+		for _, v := range values {
+			if v == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MemoryUserModel is the in-memory implementation of UserStore.
+type MemoryUserModel struct {
+	store *memoryStore
+}
+
+// emailTaken reports whether another user already holds the email. Emails
+// compare case-insensitively, like the citext column they mirror.
+func (s *memoryStore) emailTaken(email string, exceptID int64) bool {
+	for _, user := range s.users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// Insert inserts a new user record into the store.
+func (m MemoryUserModel) Insert(ctx context.Context, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	m.store.lastUserID++
+	user.ID = m.store.lastUserID
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+
+	m.store.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+// Register inserts a new user together with the named role, if any, an
+// activation token and the job built by welcome to send it. Nothing is
+// stored unless every step succeeds.
+func (m MemoryUserModel) Register(ctx context.Context, user *User, role string, activationTTL time.Duration, welcome func(token *Token) (*Job, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	id := m.store.lastUserID + 1
+
+	token, err := generateToken(id, activationTTL, ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	user.ID = id
+	job, err := welcome(token)
+	if err != nil {
+		user.ID = 0
+		return err
+	}
+
+	m.store.lastUserID = id
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+	m.store.users[user.ID] = copyUser(user)
+
+	if role != "" {
+		for _, r := range m.store.roles {
+			if r.Name == role {
+				m.store.userRoles[user.ID] = map[int64]bool{r.ID: true}
+			}
+		}
+	}
+
+	cp := *token
+	cp.Plaintext = ""
+	m.store.tokens[string(token.Hash)] = &cp
+
+	m.store.enqueueJob(job)
+
+	return nil
+}
+
+// Get retrieves a specific user record with the id
+func (m MemoryUserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	user, ok := m.store.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// GetByEmail retrieves a specific user record with the email
+func (m MemoryUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	for _, user := range m.store.users {
+		if strings.EqualFold(user.Email, email) {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+// Update updates a record with the user args passed.
+func (m MemoryUserModel) Update(ctx context.Context, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	current, ok := m.store.users[user.ID]
+	if !ok || current.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if m.store.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+	updated := copyUser(user)
+	updated.CreatedAt = current.CreatedAt
+	m.store.users[user.ID] = updated
+
+	return nil
+}
+
+// GetForToken retrieves a user record associated to an unexpired token.
+func (m MemoryUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	user, ok := m.store.users[token.UserID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	// Like UserModel, leave the password hash out of authentication
+	// lookups.
+	cp := copyUser(user)
+	if tokenScope == ScopeAuthentication {
+		cp.Password.hash = nil
+	}
+
+	return cp, nil
+}
+
+// GetAll searches users by name and email fragments and, when activated
+// is not nil, by activation status.
+func (m MemoryUserModel) GetAll(ctx context.Context, name, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+	name, email = strings.ToLower(name), strings.ToLower(email)
+
+	m.store.mu.RLock()
+	matched := []*User{}
+	for _, user := range m.store.users {
+		if !strings.Contains(strings.ToLower(user.Name), name) ||
+			!strings.Contains(strings.ToLower(user.Email), email) ||
+			(activated != nil && user.Activated != *activated) {
+			continue
+		}
+		matched = append(matched, copyUser(user))
+	}
+	m.store.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+
+		c := 0
+		switch column {
+		case "name":
+			c = strings.Compare(a.Name, b.Name)
+		case "email":
+			c = strings.Compare(a.Email, b.Email)
+		case "created_at":
+			switch {
+			case a.CreatedAt.Before(b.CreatedAt):
+				c = -1
+			case a.CreatedAt.After(b.CreatedAt):
+				c = 1
+			}
+		case "id":
+			c = int(a.ID - b.ID)
+		}
+		if c != 0 {
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
+
+	totalRecords := len(matched)
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	users := matched[start:end]
+	if len(users) == 0 {
+		return users, Metadata{}, nil
+	}
+
+	return users, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Delete deletes a user record along with their tokens, permissions and reviews.
+func (m MemoryUserModel) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.users[id]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.store.users, id)
+	delete(m.store.userPermissions, id)
+	delete(m.store.userRoles, id)
+
+	for hash, token := range m.store.tokens {
+		if token.UserID == id {
+			delete(m.store.tokens, hash)
+		}
+	}
+
+	for reviewID, review := range m.store.reviews {
+		if review.UserID == id {
+			delete(m.store.reviews, reviewID)
+			m.store.refreshMovieRating(review.MovieID)
+		}
+	}
+
+	for _, revisions := range m.store.revisions {
+		for _, rev := range revisions {
+			if rev.UserID != nil && *rev.UserID == id {
+				rev.UserID = nil
+			}
+		}
+	}
+
+	for _, failure := range m.store.loginFailures {
+		if failure.UserID != nil && *failure.UserID == id {
+			failure.UserID = nil
+		}
+	}
+
+	return nil
+}
+
+// MemoryTokenModel is the in-memory implementation of TokenStore.
+type MemoryTokenModel struct {
+	store *memoryStore
+}
+
+// New generates a token and records it in the store.
+func (m MemoryTokenModel) New(ctx context.Context, userID int64, lifeSpan time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, lifeSpan, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// Insert adds a new token record to the store.
+func (m MemoryTokenModel) Insert(ctx context.Context, token *Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.users[token.UserID]; !ok {
+		return errors.New("token references an unknown user")
+	}
+
+	cp := *token
+	cp.Plaintext = ""
+	m.store.tokens[string(token.Hash)] = &cp
+
+	return nil
+}
+
+// DeleteAllForUser deletes all tokens for a specific user and scope.
+func (m MemoryTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, token := range m.store.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(m.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+// NewInFamily generates a token belonging to a login session.
+func (m MemoryTokenModel) NewInFamily(ctx context.Context, userID int64, lifeSpan time.Duration, scope, family string) (*Token, error) {
+	token, err := generateToken(userID, lifeSpan, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = family
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// Consume marks an unexpired token as used and returns it, reporting
+// ErrTokenReused for a token that was already used.
+func (m MemoryTokenModel) Consume(ctx context.Context, scope, tokenPlaintext string) (*Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != scope {
+		return nil, ErrRecordNotFound
+	}
+
+	cp := *token
+	if token.Used {
+		return &cp, ErrTokenReused
+	}
+	if !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	token.Used, cp.Used = true, true
+
+	return &cp, nil
+}
+
+// Revoke deletes a token, along with every other token of its login
+// session when it belongs to one.
+func (m MemoryTokenModel) Revoke(ctx context.Context, scope, tokenPlaintext string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != scope {
+		return ErrRecordNotFound
+	}
+	delete(m.store.tokens, string(tokenHash[:]))
+
+	m.store.deleteTokenFamily(token.Family)
+
+	return nil
+}
+
+// DeleteFamily deletes every token of a login session.
+func (m MemoryTokenModel) DeleteFamily(ctx context.Context, family string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.deleteTokenFamily(family)
+
+	return nil
+}
+
+// deleteTokenFamily removes the tokens of a login session. The caller must
+// hold the store's write lock.
+func (s *memoryStore) deleteTokenFamily(family string) {
+	if family == "" {
+		return
+	}
+
+	for hash, token := range s.tokens {
+		if token.Family == family {
+			delete(s.tokens, hash)
+		}
+	}
+}
+
+// MemoryPermissionsModel is the in-memory implementation of PermissionStore.
+type MemoryPermissionsModel struct {
+	store *memoryStore
+}
+
+// GetAllForUser returns all the permission a user has, whether granted
+// directly or through one of their roles.
+func (m MemoryPermissionsModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	granted := make(map[string]bool)
+	for code := range m.store.userPermissions[userID] {
+		granted[code] = true
+	}
+	for roleID := range m.store.userRoles[userID] {
+		for _, code := range m.store.roles[roleID].Permissions {
+			granted[code] = true
+		}
+	}
+
+	var permissions Permissions
+	for _, code := range m.store.permissions {
+		if granted[code] {
+			permissions = append(permissions, code)
+		}
+	}
+	sort.Strings(permissions)
+
+	return permissions, nil
+}
+
+// AddForUser grants the known permission codes to a user. Unknown codes
+// are ignored, as they are by the SQL implementation.
+func (m MemoryPermissionsModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.users[userID]; !ok {
+		return errors.New("permission references an unknown user")
+	}
+
+	granted, ok := m.store.userPermissions[userID]
+	if !ok {
+		granted = make(map[string]bool)
+		m.store.userPermissions[userID] = granted
+	}
+
+	for _, code := range codes {
+		for _, known := range m.store.permissions {
+			if code == known {
+				granted[code] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// MemoryPersonModel is the in-memory implementation of PersonStore.
+type MemoryPersonModel struct {
+	store *memoryStore
+}
+
+// Insert adds a new person record to the store.
+func (m MemoryPersonModel) Insert(ctx context.Context, person *Person) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.lastPersonID++
+	person.ID = m.store.lastPersonID
+	person.CreatedAt = time.Now().Truncate(time.Second)
+	person.Version = 1
+
+	cp := *person
+	m.store.people[person.ID] = &cp
+
+	return nil
+}
+
+// Get fetches a specific person record with the id
+func (m MemoryPersonModel) Get(ctx context.Context, id int64) (*Person, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	person, ok := m.store.people[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	cp := *person
+	return &cp, nil
+}
+
+// GetAll gets all the people whose name matches.
+func (m MemoryPersonModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+	terms := lexemes(name)
+
+	m.store.mu.RLock()
+	matched := []*Person{}
+	for _, person := range m.store.people {
+		if matchesLexemes(person.Name, terms) {
+			cp := *person
+			matched = append(matched, &cp)
+		}
+	}
+	m.store.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+
+		c := 0
+		switch column {
+		case "name":
+			c = strings.Compare(a.Name, b.Name)
+		case "birth_year":
+			c = int(a.BirthYear) - int(b.BirthYear)
+		case "id":
+			c = int(a.ID - b.ID)
+		}
+		if c != 0 {
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
+
+	totalRecords := len(matched)
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	people := matched[start:end]
+	if len(people) == 0 {
+		return people, Metadata{}, nil
+	}
+
+	return people, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Update updates a record with the person arg passed.
+func (m MemoryPersonModel) Update(ctx context.Context, person *Person) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	current, ok := m.store.people[person.ID]
+	if !ok || current.Version != person.Version {
+		return ErrEditConflict
+	}
+
+	person.Version++
+	cp := *person
+	cp.CreatedAt = current.CreatedAt
+	m.store.people[person.ID] = &cp
+
+	return nil
+}
+
+// Delete deletes a specific person record, along with their credits.
+func (m MemoryPersonModel) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.people[id]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.store.people, id)
+
+	for creditID, credit := range m.store.credits {
+		if credit.PersonID == id {
+			delete(m.store.credits, creditID)
+		}
+	}
+
+	return nil
+}
+
+// AddCredit records a person's role on a movie.
+func (m MemoryPersonModel) AddCredit(ctx context.Context, credit *Credit) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if !m.store.liveMovie(credit.MovieID) {
+		return ErrRecordNotFound
+	}
+	if _, ok := m.store.people[credit.PersonID]; !ok {
+		return errors.New("credit references an unknown person")
+	}
+
+	for _, existing := range m.store.credits {
+		if existing.MovieID == credit.MovieID && existing.PersonID == credit.PersonID &&
+			existing.Role == credit.Role && existing.Character == credit.Character {
+			return ErrDuplicateCredit
+		}
+	}
+
+	m.store.lastCreditID++
+	credit.ID = m.store.lastCreditID
+
+	cp := *credit
+	cp.Name = ""
+	m.store.credits[credit.ID] = &cp
+
+	return nil
+}
+
+// GetCreditsForMovie returns the cast and crew of a movie, directors
+// first and then in the order they were credited.
+func (m MemoryPersonModel) GetCreditsForMovie(ctx context.Context, movieID int64) ([]*Credit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	credits := []*Credit{}
+	for _, credit := range m.store.credits {
+		if credit.MovieID != movieID {
+			continue
+		}
+
+		cp := *credit
+		if person, ok := m.store.people[credit.PersonID]; ok {
+			cp.Name = person.Name
+		}
+		credits = append(credits, &cp)
+	}
+
+	sort.Slice(credits, func(i, j int) bool {
+		iDirector, jDirector := credits[i].Role == RoleDirector, credits[j].Role == RoleDirector
+		if iDirector != jDirector {
+			return iDirector
+		}
+		return credits[i].ID < credits[j].ID
+	})
+
+	return credits, nil
+}
+
+// DeleteCredit removes a credit from a movie.
+func (m MemoryPersonModel) DeleteCredit(ctx context.Context, movieID, creditID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	credit, ok := m.store.credits[creditID]
+	if !ok || credit.MovieID != movieID {
+		return ErrRecordNotFound
+	}
+	delete(m.store.credits, creditID)
+
+	return nil
+}
+
+// liveMovie reports whether a movie exists and isn't in the trash. The
+// caller holds s.mu.
+func (s *memoryStore) liveMovie(id int64) bool {
+	movie, ok := s.movies[id]
+	return ok && movie.DeletedAt == nil
+}
+
+// MemoryReviewModel is the in-memory implementation of ReviewStore.
+type MemoryReviewModel struct {
+	store *memoryStore
+}
+
+// refreshMovieRating recomputes the rating aggregates of a movie. The
+// caller must hold the store's write lock.
+func (s *memoryStore) refreshMovieRating(movieID int64) {
+	movie, ok := s.movies[movieID]
+	if !ok {
+		return
+	}
+
+	var sum, count int64
+	for _, review := range s.reviews {
+		if review.MovieID == movieID {
+			sum += int64(review.Rating)
+			count++
+		}
+	}
+
+	movie.AverageRating, movie.RatingCount = 0, int32(count)
+	if count > 0 {
+		movie.AverageRating = math.Round(float64(sum)/float64(count)*100) / 100
+	}
+}
+
+// Insert adds a new review and updates the movie's rating aggregates.
+func (m MemoryReviewModel) Insert(ctx context.Context, review *Review) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if !m.store.liveMovie(review.MovieID) {
+		return ErrRecordNotFound
+	}
+	if _, ok := m.store.users[review.UserID]; !ok {
+		return errors.New("review references an unknown user")
+	}
+
+	for _, existing := range m.store.reviews {
+		if existing.MovieID == review.MovieID && existing.UserID == review.UserID {
+			return ErrDuplicateReview
+		}
+	}
+
+	m.store.lastReviewID++
+	review.ID = m.store.lastReviewID
+	review.CreatedAt = time.Now().Truncate(time.Second)
+	review.UpdatedAt = review.CreatedAt
+	review.Version = 1
+
+	cp := *review
+	m.store.reviews[review.ID] = &cp
+	m.store.refreshMovieRating(review.MovieID)
+
+	return nil
+}
+
+// Get fetches a specific review of a movie.
+func (m MemoryReviewModel) Get(ctx context.Context, movieID, id int64) (*Review, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	review, ok := m.store.reviews[id]
+	if !ok || review.MovieID != movieID {
+		return nil, ErrRecordNotFound
+	}
+
+	cp := *review
+	return &cp, nil
+}
+
+// GetAllForMovie returns a page of the reviews of a movie.
+func (m MemoryReviewModel) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+
+	m.store.mu.RLock()
+	matched := []*Review{}
+	for _, review := range m.store.reviews {
+		if review.MovieID == movieID {
+			cp := *review
+			matched = append(matched, &cp)
+		}
+	}
+	m.store.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+
+		c := 0
+		switch column {
+		case "rating":
+			c = int(a.Rating) - int(b.Rating)
+		case "created_at":
+			switch {
+			case a.CreatedAt.Before(b.CreatedAt):
+				c = -1
+			case a.CreatedAt.After(b.CreatedAt):
+				c = 1
+			}
+		case "id":
+			c = int(a.ID - b.ID)
+		}
+		if c != 0 {
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
+
+	totalRecords := len(matched)
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	reviews := matched[start:end]
+	if len(reviews) == 0 {
+		return reviews, Metadata{}, nil
+	}
+
+	return reviews, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Update updates a review, guarding against concurrent edits with its
+// version number, and refreshes the movie's rating aggregates.
+func (m MemoryReviewModel) Update(ctx context.Context, review *Review) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if !m.store.liveMovie(review.MovieID) {
+		return ErrRecordNotFound
+	}
+
+	current, ok := m.store.reviews[review.ID]
+	if !ok || current.Version != review.Version {
+		return ErrEditConflict
+	}
+
+	review.Version++
+	review.UpdatedAt = time.Now().Truncate(time.Second)
+
+	cp := *current
+	cp.Rating, cp.Text = review.Rating, review.Text
+	cp.UpdatedAt, cp.Version = review.UpdatedAt, review.Version
+	m.store.reviews[review.ID] = &cp
+	m.store.refreshMovieRating(cp.MovieID)
+
+	return nil
+}
+
+// Delete deletes a review of a movie and refreshes the movie's rating aggregates.
+func (m MemoryReviewModel) Delete(ctx context.Context, movieID, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	review, ok := m.store.reviews[id]
+	if !ok || review.MovieID != movieID || !m.store.liveMovie(movieID) {
+		return ErrRecordNotFound
+	}
+	delete(m.store.reviews, id)
+	m.store.refreshMovieRating(movieID)
+
+	return nil
+}
+
+// RemoveForUser revokes the given permissions from a user.
+func (m MemoryPermissionsModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, code := range codes {
+		delete(m.store.userPermissions[userID], code)
+	}
+
+	return nil
+}
+
+// GetAll returns every permission code that can be granted.
+func (m MemoryPermissionsModel) GetAll(ctx context.Context) (Permissions, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	permissions := append(Permissions{}, m.store.permissions...)
+	sort.Strings(permissions)
+
+	return permissions, nil
+}
+
+// MemoryRoleModel is the in-memory implementation of RoleStore.
+type MemoryRoleModel struct {
+	store *memoryStore
+}
+
+func copyRole(role *Role) *Role {
+	cp := *role
+	cp.Permissions = append(Permissions{}, role.Permissions...)
+	return &cp
+}
+
+// knownPermissions keeps the codes that exist in the store, in sorted
+// order, mirroring how the SQL implementation ignores unknown codes.
+func (s *memoryStore) knownPermissions(codes Permissions) Permissions {
+	known := Permissions{}
+	for _, code := range s.permissions {
+		if codes.Include(code) {
+			known = append(known, code)
+		}
+	}
+	sort.Strings(known)
+
+	return known
+}
+
+func (s *memoryStore) roleNameTaken(name string, exceptID int64) bool {
+	for _, role := range s.roles {
+		if role.Name == name && role.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+// Insert adds a new role to the store.
+func (m MemoryRoleModel) Insert(ctx context.Context, role *Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.roleNameTaken(role.Name, 0) {
+		return ErrDuplicateRole
+	}
+
+	m.store.lastRoleID++
+	role.ID = m.store.lastRoleID
+	role.Version = 1
+
+	stored := copyRole(role)
+	stored.Permissions = m.store.knownPermissions(role.Permissions)
+	m.store.roles[role.ID] = stored
+
+	return nil
+}
+
+// Get fetches a specific role by its id.
+func (m MemoryRoleModel) Get(ctx context.Context, id int64) (*Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	role, ok := m.store.roles[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyRole(role), nil
+}
+
+// GetByName fetches a specific role by its name.
+func (m MemoryRoleModel) GetByName(ctx context.Context, name string) (*Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	for _, role := range m.store.roles {
+		if role.Name == name {
+			return copyRole(role), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+// GetAll returns every role, ordered by name.
+func (m MemoryRoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	roles := []*Role{}
+	for _, role := range m.store.roles {
+		roles = append(roles, copyRole(role))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+
+	return roles, nil
+}
+
+// Update updates a role and replaces its permission codes, guarding
+// against concurrent edits with its version number.
+func (m MemoryRoleModel) Update(ctx context.Context, role *Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.roles[role.ID]
+	if !ok || stored.Version != role.Version {
+		return ErrEditConflict
+	}
+	if m.store.roleNameTaken(role.Name, role.ID) {
+		return ErrDuplicateRole
+	}
+
+	role.Version++
+
+	updated := copyRole(role)
+	updated.Permissions = m.store.knownPermissions(role.Permissions)
+	m.store.roles[role.ID] = updated
+
+	return nil
+}
+
+// Delete deletes a role, unassigning it from every user that held it.
+func (m MemoryRoleModel) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.roles[id]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.store.roles, id)
+
+	for _, assigned := range m.store.userRoles {
+		delete(assigned, id)
+	}
+
+	return nil
+}
+
+// GetHolders returns the IDs of the users a role is assigned to.
+func (m MemoryRoleModel) GetHolders(ctx context.Context, id int64) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	userIDs := []int64{}
+	for userID, assigned := range m.store.userRoles {
+		if assigned[id] {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+
+	return userIDs, nil
+}
+
+// GetAllForUser returns the names of the roles assigned to a user.
+func (m MemoryRoleModel) GetAllForUser(ctx context.Context, userID int64) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	names := []string{}
+	for roleID := range m.store.userRoles[userID] {
+		names = append(names, m.store.roles[roleID].Name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// AddForUser assigns the named roles to a user. Unknown names are ignored,
+// as they are by the SQL implementation.
+func (m MemoryRoleModel) AddForUser(ctx context.Context, userID int64, names ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.users[userID]; !ok {
+		return errors.New("role references an unknown user")
+	}
+
+	assigned, ok := m.store.userRoles[userID]
+	if !ok {
+		assigned = make(map[int64]bool)
+		m.store.userRoles[userID] = assigned
+	}
+
+	for _, role := range m.store.roles {
+		for _, name := range names {
+			if role.Name == name {
+				assigned[role.ID] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// RemoveForUser unassigns the named roles from a user.
+func (m MemoryRoleModel) RemoveForUser(ctx context.Context, userID int64, names ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, role := range m.store.roles {
+		for _, name := range names {
+			if role.Name == name {
+				delete(m.store.userRoles[userID], role.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+func copyJob(job *Job) *Job {
+	cp := *job
+	cp.Payload = append(json.RawMessage{}, job.Payload...)
+	if job.LockedUntil != nil {
+		lockedUntil := *job.LockedUntil
+		cp.LockedUntil = &lockedUntil
+	}
+	return &cp
+}
+
+// MemoryJobModel is the in-memory implementation of JobStore. Its jobs are
+// lost with the process, so it only suits development.
+type MemoryJobModel struct {
+	store *memoryStore
+}
+
+// Enqueue adds a pending job to the store.
+func (m MemoryJobModel) Enqueue(ctx context.Context, job *Job) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.enqueueJob(job)
+
+	return nil
+}
+
+func (s *memoryStore) enqueueJob(job *Job) {
+	now := time.Now()
+
+	s.lastJobID++
+	job.ID = s.lastJobID
+	job.CreatedAt, job.UpdatedAt = now.Truncate(time.Second), now.Truncate(time.Second)
+	job.State, job.Attempts, job.LockedUntil, job.LastError = JobPending, 0, nil, ""
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+
+	s.jobs[job.ID] = copyJob(job)
+}
+
+// Claim marks the next due job of one of the given kinds as running for
+// the length of lease, counting an attempt, and returns it.
+func (m MemoryJobModel) Claim(ctx context.Context, kinds []string, lease time.Duration) (*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	now := time.Now()
+
+	var next *Job
+	for _, job := range m.store.jobs {
+		due := (job.State == JobPending && !job.RunAt.After(now)) ||
+			(job.State == JobRunning && job.LockedUntil.Before(now))
+		if !due || !containsAll(kinds, []string{job.Kind}) {
+			continue
+		}
+		if next == nil || job.RunAt.Before(next.RunAt) || (job.RunAt.Equal(next.RunAt) && job.ID < next.ID) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	lockedUntil := now.Add(lease)
+	next.State, next.LockedUntil, next.UpdatedAt = JobRunning, &lockedUntil, now.Truncate(time.Second)
+	next.Attempts++
+
+	return copyJob(next), nil
+}
+
+// runningJob returns a job if it is running. The caller must hold the
+// store's lock.
+func (s *memoryStore) runningJob(id int64) (*Job, bool) {
+	job, ok := s.jobs[id]
+	return job, ok && job.State == JobRunning
+}
+
+// Complete deletes a job that ran successfully.
+func (m MemoryJobModel) Complete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.runningJob(id); ok {
+		delete(m.store.jobs, id)
+	}
+
+	return nil
+}
+
+// Reschedule returns a running job that failed to pending, to be tried
+// again at runAt.
+func (m MemoryJobModel) Reschedule(ctx context.Context, id int64, runAt time.Time, lastError string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if job, ok := m.store.runningJob(id); ok {
+		job.State, job.RunAt, job.LockedUntil, job.LastError = JobPending, runAt, nil, lastError
+		job.UpdatedAt = time.Now().Truncate(time.Second)
+	}
+
+	return nil
+}
+
+// Bury marks a running job dead.
+func (m MemoryJobModel) Bury(ctx context.Context, id int64, lastError string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if job, ok := m.store.runningJob(id); ok {
+		job.State, job.LockedUntil, job.LastError = JobDead, nil, lastError
+		job.UpdatedAt = time.Now().Truncate(time.Second)
+	}
+
+	return nil
+}
+
+// Retry makes a dead job pending again with a fresh set of attempts.
+func (m MemoryJobModel) Retry(ctx context.Context, id int64) (*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	job, ok := m.store.jobs[id]
+	if !ok || job.State != JobDead {
+		return nil, ErrRecordNotFound
+	}
+
+	now := time.Now()
+	job.State, job.Attempts, job.RunAt, job.UpdatedAt = JobPending, 0, now, now.Truncate(time.Second)
+
+	return copyJob(job), nil
+}
+
+// Get fetches a specific job.
+func (m MemoryJobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	job, ok := m.store.jobs[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyJob(job), nil
+}
+
+// GetAll returns a page of the jobs, optionally only those in one state or
+// of one kind.
+func (m MemoryJobModel) GetAll(ctx context.Context, state, kind string, filters Filters) ([]*Job, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	m.store.mu.RLock()
+	matched := []*Job{}
+	for _, job := range m.store.jobs {
+		if (state == "" || job.State == state) && (kind == "" || job.Kind == kind) {
+			matched = append(matched, copyJob(job))
+		}
+	}
+	m.store.mu.RUnlock()
+
+	column, desc := filters.sortColumn(), filters.sortDirection() == "DESC"
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+
+		var c int
+		switch column {
+		case "run_at":
+			c = compareTimes(a.RunAt, b.RunAt)
+		case "updated_at":
+			c = compareTimes(a.UpdatedAt, b.UpdatedAt)
+		default:
+			c = int(a.ID - b.ID)
+		}
+		if c != 0 {
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
+
+	totalRecords := len(matched)
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return matched[start:end], calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// MemoryLoginThrottleModel is the in-memory implementation of
+// LoginThrottleStore.
+type MemoryLoginThrottleModel struct {
+	store *memoryStore
+}
+
+// Attempt records a login attempt with email under policy, before its
+// password is checked. Emails compare case-insensitively, like the citext
+// column they mirror.
+func (m MemoryLoginThrottleModel) Attempt(ctx context.Context, email string, policy LockoutPolicy) (*LoginAttempt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	key := strings.ToLower(email)
+
+	throttle, ok := m.store.loginThrottles[key]
+	if !ok {
+		throttle = &LoginThrottle{Email: email}
+		m.store.loginThrottles[key] = throttle
+	}
+
+	now := time.Now()
+	throttle.UpdatedAt = now
+
+	var attempt LoginAttempt
+
+	if attempt.Wait, attempt.Locked = throttle.Wait(now); attempt.Wait > 0 {
+		return &attempt, nil
+	}
+
+	if policy.apply(throttle, now) {
+		lockedUntil := *throttle.LockedUntil
+		attempt.LockedUntil = &lockedUntil
+	}
+
+	return &attempt, nil
+}
+
+// Purge deletes the throttles that impose no wait and have seen no attempt
+// since before cutoff.
+func (m MemoryLoginThrottleModel) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	now := time.Now()
+
+	var purged int64
+
+	for key, throttle := range m.store.loginThrottles {
+		if wait, _ := throttle.Wait(now); wait > 0 || !throttle.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		delete(m.store.loginThrottles, key)
+		purged++
+	}
+
+	return purged, nil
+}
+
+// Reset clears the failures with an email and any lock.
+func (m MemoryLoginThrottleModel) Reset(ctx context.Context, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	delete(m.store.loginThrottles, strings.ToLower(email))
+	return nil
+}
+
+// MemoryLoginFailureModel is the in-memory implementation of
+// LoginFailureStore.
+type MemoryLoginFailureModel struct {
+	store *memoryStore
+}
+
+func copyLoginFailure(failure *LoginFailure) *LoginFailure {
+	cp := *failure
+	if failure.UserID != nil {
+		userID := *failure.UserID
+		cp.UserID = &userID
+	}
+	return &cp
+}
+
+// Insert adds an entry to the audit log.
+func (m MemoryLoginFailureModel) Insert(ctx context.Context, failure *LoginFailure) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	failure.ID = int64(len(m.store.loginFailures)) + 1
+	failure.CreatedAt = time.Now().Truncate(time.Second)
+	m.store.loginFailures = append(m.store.loginFailures, copyLoginFailure(failure))
+
+	return nil
+}
+
+// GetAll returns a page of the audit log, optionally only the entries for
+// an email address or a user.
+func (m MemoryLoginFailureModel) GetAll(ctx context.Context, email string, userID int64, filters Filters) ([]*LoginFailure, Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	m.store.mu.RLock()
+	matched := []*LoginFailure{}
+	for _, failure := range m.store.loginFailures {
+		if email != "" && !strings.EqualFold(failure.Email, email) {
+			continue
+		}
+		if userID != 0 && (failure.UserID == nil || *failure.UserID != userID) {
+			continue
+		}
+		matched = append(matched, copyLoginFailure(failure))
+	}
+	m.store.mu.RUnlock()
+
+	// Entries are appended in id order, which is also created_at order.
+	if filters.sortDirection() == "DESC" {
+		for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+			matched[i], matched[j] = matched[j], matched[i]
+		}
+	}
+
+	totalRecords := len(matched)
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return matched[start:end], calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
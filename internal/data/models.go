@@ -1,8 +1,14 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/lighten/internal/tracing"
 )
 
 var (
@@ -10,18 +16,216 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DefaultQueryTimeout bounds a single query when no other timeout is configured.
+const DefaultQueryTimeout = 3 * time.Second
+
+// MovieStore describes the operations available on movie records.
+type MovieStore interface {
+	Insert(ctx context.Context, movie *Movie, userID int64) error
+	InsertMany(ctx context.Context, movies []*Movie, userID int64) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	GetAll(ctx context.Context, title string, genres []string, personID int64, director string, filters Filters) ([]*Movie, Metadata, error)
+	Update(ctx context.Context, movie *Movie, userID int64) error
+	Revert(ctx context.Context, movie *Movie, version int32, userID int64) error
+	Delete(ctx context.Context, id int64, version int32, userID int64) error
+	Restore(ctx context.Context, id, userID int64) error
+	GetDeleted(ctx context.Context, filters Filters) ([]*Movie, Metadata, error)
+	Purge(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// MovieRevisionStore describes the operations available on the revision
+// history of movies. Revisions are recorded by the MovieStore.
+type MovieRevisionStore interface {
+	GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error)
+	Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error)
+}
+
+// UserStore describes the operations available on user records.
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	Register(ctx context.Context, user *User, role string, activationTTL time.Duration, welcome func(token *Token) (*Job, error)) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	GetAll(ctx context.Context, name, email string, activated *bool, filters Filters) ([]*User, Metadata, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+// TokenStore describes the operations available on token records.
+type TokenStore interface {
+	New(ctx context.Context, userID int64, lifeSpan time.Duration, scope string) (*Token, error)
+	NewInFamily(ctx context.Context, userID int64, lifeSpan time.Duration, scope, family string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	Consume(ctx context.Context, scope, tokenPlaintext string) (*Token, error)
+	Revoke(ctx context.Context, scope, tokenPlaintext string) error
+	DeleteFamily(ctx context.Context, family string) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+}
+
+// PermissionStore describes the operations available on user permissions.
+type PermissionStore interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	RemoveForUser(ctx context.Context, userID int64, codes ...string) error
+	GetAll(ctx context.Context) (Permissions, error)
+}
+
+// RoleStore describes the operations available on roles and their
+// assignment to users.
+type RoleStore interface {
+	Insert(ctx context.Context, role *Role) error
+	Get(ctx context.Context, id int64) (*Role, error)
+	GetByName(ctx context.Context, name string) (*Role, error)
+	GetAll(ctx context.Context) ([]*Role, error)
+	Update(ctx context.Context, role *Role) error
+	Delete(ctx context.Context, id int64) error
+	GetHolders(ctx context.Context, id int64) ([]int64, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]string, error)
+	AddForUser(ctx context.Context, userID int64, names ...string) error
+	RemoveForUser(ctx context.Context, userID int64, names ...string) error
+}
+
+// PersonStore describes the operations available on people and the
+// credits linking them to movies.
+type PersonStore interface {
+	Insert(ctx context.Context, person *Person) error
+	Get(ctx context.Context, id int64) (*Person, error)
+	GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error)
+	Update(ctx context.Context, person *Person) error
+	Delete(ctx context.Context, id int64) error
+	AddCredit(ctx context.Context, credit *Credit) error
+	GetCreditsForMovie(ctx context.Context, movieID int64) ([]*Credit, error)
+	DeleteCredit(ctx context.Context, movieID, creditID int64) error
+}
+
+// ReviewStore describes the operations available on movie reviews.
+type ReviewStore interface {
+	Insert(ctx context.Context, review *Review) error
+	Get(ctx context.Context, movieID, id int64) (*Review, error)
+	GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*Review, Metadata, error)
+	Update(ctx context.Context, review *Review) error
+	Delete(ctx context.Context, movieID, id int64) error
+}
+
+// JobStore describes the operations available on the job queue.
+type JobStore interface {
+	Enqueue(ctx context.Context, job *Job) error
+	Claim(ctx context.Context, kinds []string, lease time.Duration) (*Job, error)
+	Complete(ctx context.Context, id int64) error
+	Reschedule(ctx context.Context, id int64, runAt time.Time, lastError string) error
+	Bury(ctx context.Context, id int64, lastError string) error
+	Retry(ctx context.Context, id int64) (*Job, error)
+	Get(ctx context.Context, id int64) (*Job, error)
+	GetAll(ctx context.Context, state, kind string, filters Filters) ([]*Job, Metadata, error)
+}
+
+// LoginThrottleStore describes the operations available on the tracking
+// of failed logins per email.
+type LoginThrottleStore interface {
+	Attempt(ctx context.Context, email string, policy LockoutPolicy) (*LoginAttempt, error)
+	Reset(ctx context.Context, email string) error
+	Purge(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// LoginFailureStore describes the operations available on the audit log
+// of failed logins.
+type LoginFailureStore interface {
+	Insert(ctx context.Context, failure *LoginFailure) error
+	GetAll(ctx context.Context, email string, userID int64, filters Filters) ([]*LoginFailure, Metadata, error)
+}
+
+// Models groups the stores used by the application, independent of
+// the storage backend behind them.
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionsModel
+	Movies         MovieStore
+	MovieRevisions MovieRevisionStore
+	Users          UserStore
+	Tokens         TokenStore
+	Permissions    PermissionStore
+	Roles          RoleStore
+	People         PersonStore
+	Reviews        ReviewStore
+	Jobs           JobStore
+	LoginThrottles LoginThrottleStore
+	LoginFailures  LoginFailureStore
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns Models backed by a PostgreSQL connection pool. Every
+// query is bounded by queryTimeout on top of the caller's context. Token,
+// user and permission lookups go through lookups unless it is nil.
+func NewModels(db *sql.DB, queryTimeout time.Duration, lookups *LookupCache) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionsModel{DB: db},
+		Movies:         MovieModel{DB: db, Timeout: queryTimeout},
+		MovieRevisions: MovieRevisionModel{DB: db, Timeout: queryTimeout},
+		Users:          UserModel{DB: db, Timeout: queryTimeout, Cache: lookups},
+		Tokens:         TokenModel{DB: db, Timeout: queryTimeout, Cache: lookups},
+		Permissions:    PermissionsModel{DB: db, Timeout: queryTimeout, Cache: lookups},
+		Roles:          RoleModel{DB: db, Timeout: queryTimeout, Cache: lookups},
+		People:         PersonModel{DB: db, Timeout: queryTimeout},
+		Reviews:        ReviewModel{DB: db, Timeout: queryTimeout},
+		Jobs:           JobModel{DB: db, Timeout: queryTimeout},
+		LoginThrottles: LoginThrottleModel{DB: db, Timeout: queryTimeout},
+		LoginFailures:  LoginFailureModel{DB: db, Timeout: queryTimeout},
 	}
 }
+
+// NewMemoryModels returns Models backed by a single in-memory store. Data
+// is lost when the process exits.
+func NewMemoryModels() Models {
+	store := newMemoryStore()
+
+	return Models{
+		Movies:         MemoryMovieModel{store: store},
+		MovieRevisions: MemoryMovieRevisionModel{store: store},
+		Users:          MemoryUserModel{store: store},
+		Tokens:         MemoryTokenModel{store: store},
+		Permissions:    MemoryPermissionsModel{store: store},
+		Roles:          MemoryRoleModel{store: store},
+		People:         MemoryPersonModel{store: store},
+		Reviews:        MemoryReviewModel{store: store},
+		Jobs:           MemoryJobModel{store: store},
+		LoginThrottles: MemoryLoginThrottleModel{store: store},
+		LoginFailures:  MemoryLoginFailureModel{store: store},
+	}
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so that a statement
+// can run on its own or as part of a larger transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// withTimeout derives the context for a single query from the caller's
+// context, so the query is cancelled when either the caller goes away or
+// the timeout elapses. It also starts a span named after the calling
+// model method, which ends when the returned cancel func is called.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+
+	ctx, span := tracing.StartKind(ctx, callerName(), tracing.KindClient)
+	span.SetAttribute("db.system", "postgresql")
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// callerName returns the name of the model method that called
+// withTimeout, e.g. "MovieModel.GetAll".
+func callerName() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return "query"
+	}
+
+	name := runtime.FuncForPC(pc).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.TrimPrefix(name, "data.")
+}
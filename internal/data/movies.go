@@ -3,8 +3,10 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/lib/pq"
@@ -13,7 +15,8 @@ import (
 
 // MovieModel wraps the sql.DB connection pool.
 type MovieModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
 type Movie struct {
@@ -24,34 +27,98 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genre,omitempty"`
 	Year      int32     `json:"year,omitempty"`
+	// AverageRating and RatingCount aggregate the movie's reviews.
+	AverageRating float64 `json:"average_rating"`
+	RatingCount   int32   `json:"rating_count"`
+	// DeletedAt is set while the movie is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
-// Insert inserts a new movie record into the movies table.
-func (m MovieModel) Insert(movie *Movie) error {
+// Insert inserts a new movie record into the movies table, recording it
+// as the movie's first revision on behalf of the user with userID.
+func (m MovieModel) Insert(ctx context.Context, movie *Movie, userID int64) error {
 	stmt := `
 		INSERT INTO movies (title, year, runtime, genres)	
 		VALUES ($1, $2, $3, $4)
 		RETURNING id, created_at, version`
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	if err = insertRevision(ctx, tx, newRevision(movie, RevisionCreate, MovieSnapshot{}, userID)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertMany inserts several movies in a single transaction, so either all
+// of them are inserted or none are. The timeout grows with the number of
+// movies, as each one takes two statements.
+func (m MovieModel) InsertMany(ctx context.Context, movies []*Movie, userID int64) error {
+	stmt := `
+		INSERT INTO movies (title, year, runtime, genres)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+
+	ctx, cancel := withTimeout(ctx, timeout*time.Duration(1+len(movies)/insertBatchSize))
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, movie := range movies {
+		args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+		err = tx.QueryRowContext(ctx, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+
+		if err = insertRevision(ctx, tx, newRevision(movie, RevisionCreate, MovieSnapshot{}, userID)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
+// insertBatchSize is the number of movies InsertMany is given one query
+// timeout for.
+const insertBatchSize = 100
+
 // Get fetches a specific movie record with the id
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	stmt := `
-		SELECT id, title, created_at, version, runtime, genres, year 
+		SELECT id, title, created_at, version, runtime, genres, year, average_rating, rating_count
 		FROM movies
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var movie Movie
@@ -64,6 +131,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Year,
+		&movie.AverageRating,
+		&movie.RatingCount,
 	)
 
 	if err != nil {
@@ -78,20 +147,44 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
+// movieFilterClause restricts movies by title, genres, a credited person
+// and a director's name, expecting those values as parameters $1 to $4.
+// Movies in the trash are always left out.
+const movieFilterClause = `deleted_at IS NULL
+		AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (genres @> $2 OR $2 = '{}')
+		AND ($3 = 0 OR EXISTS (
+			SELECT 1 FROM movie_credits
+			WHERE movie_credits.movie_id = movies.id AND movie_credits.person_id = $3))
+		AND ($4 = '' OR EXISTS (
+			SELECT 1 FROM movie_credits
+			INNER JOIN people ON people.id = movie_credits.person_id
+			WHERE movie_credits.movie_id = movies.id AND movie_credits.role = 'director'
+			AND to_tsvector('simple', people.name) @@ plainto_tsquery('simple', $4)))`
+
 // GetAll gets all the movie record that's matched by the query_string.
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// When the filters carry a cursor the page is fetched with a keyset query
+// instead of LIMIT/OFFSET, which stays fast deep into the catalogue.
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, personID int64, director string, filters Filters) ([]*Movie, Metadata, error) {
+	c, err := filters.decodeCursor()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if c != nil {
+		return m.getAllByCursor(ctx, title, genres, personID, director, filters, c)
+	}
+
 	stmt := fmt.Sprintf(
 		`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version 
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, average_rating, rating_count
 		FROM movies 
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-		AND (genres @> $2 OR $2 = '{}')
+		WHERE %s
 		ORDER BY %s %s, id ASC 
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection(),
+		LIMIT $5 OFFSET $6`, movieFilterClause, filters.sortColumn(), filters.sortDirection(),
 	)
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := []interface{}{title, pq.Array(genres), personID, director, filters.limit(), filters.offset()}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, stmt, args...)
@@ -113,6 +206,8 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -120,20 +215,170 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		movies = append(movies, &movie)
 	}
 
-	if rows.Err() != nil {
+	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 	metadata := calcMetadata(totalRecords, filters.Page, filters.PageSize)
+	if len(movies) > 0 {
+		column := filters.sortColumn()
+		metadata.NextCursor, metadata.PrevCursor = filters.pageCursors(
+			movieCursor(movies[0], column),
+			movieCursor(movies[len(movies)-1], column),
+			filters.Page < metadata.LastPage,
+			filters.Page > 1,
+		)
+	}
 
 	return movies, metadata, nil
 }
 
-// Update updates a record with the movie arg passed.
-func (m MovieModel) Update(movie *Movie) error {
+// getAllByCursor fetches the page of movies adjacent to the cursor. One
+// extra row is requested to learn whether another page follows.
+func (m MovieModel) getAllByCursor(ctx context.Context, title string, genres []string, personID int64, director string, filters Filters, c *cursor) ([]*Movie, Metadata, error) {
+	columnOp, idOp, columnOrder, idOrder := filters.keysetClause(c)
+
+	stmt := fmt.Sprintf(
+		`
+		SELECT id, created_at, title, year, runtime, genres, version, average_rating, rating_count
+		FROM movies 
+		WHERE %[6]s
+		AND (%[1]s %[2]s $5 OR (%[1]s = $5 AND id %[3]s $6))
+		ORDER BY %[1]s %[4]s, id %[5]s 
+		LIMIT $7`, filters.sortColumn(), columnOp, idOp, columnOrder, idOrder, movieFilterClause,
+	)
+	args := []interface{}{title, pq.Array(genres), personID, director, c.Value, c.ID, filters.limit() + 1}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	movies, metadata := keysetPage(movies, filters, c)
+	return movies, metadata, nil
+}
+
+// keysetPage drops the extra row fetched to detect further pages, restores
+// the display order of a backward page and builds the page's cursors.
+func keysetPage(movies []*Movie, filters Filters, c *cursor) ([]*Movie, Metadata) {
+	hasMore := len(movies) > filters.limit()
+	if hasMore {
+		movies = movies[:filters.limit()]
+	}
+
+	if c.Backward {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if len(movies) == 0 {
+		return movies, metadata
+	}
+
+	hasNext, hasPrev := hasMore, true
+	if c.Backward {
+		hasNext, hasPrev = true, hasMore
+	}
+
+	column := filters.sortColumn()
+	metadata.NextCursor, metadata.PrevCursor = filters.pageCursors(
+		movieCursor(movies[0], column),
+		movieCursor(movies[len(movies)-1], column),
+		hasNext,
+		hasPrev,
+	)
+
+	return movies, metadata
+}
+
+// movieCursor captures the sort key and id of a movie for a cursor.
+func movieCursor(movie *Movie, column string) cursor {
+	c := cursor{ID: movie.ID}
+
+	switch column {
+	case "title":
+		c.Value = movie.Title
+	case "year":
+		c.Value = strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		c.Value = strconv.FormatInt(int64(movie.Runtime), 10)
+	case "average_rating":
+		c.Value = strconv.FormatFloat(movie.AverageRating, 'f', -1, 64)
+	case "rating_count":
+		c.Value = strconv.FormatInt(int64(movie.RatingCount), 10)
+	default:
+		c.Value = strconv.FormatInt(movie.ID, 10)
+	}
+
+	return c
+}
+
+// lockMovieVersion locks a movie that is not in the trash for the rest of
+// the transaction and returns its snapshot, failing with ErrEditConflict
+// unless it is still at version.
+func lockMovieVersion(ctx context.Context, tx *sql.Tx, id int64, version int32) (MovieSnapshot, error) {
+	stmt := `
+	SELECT title, year, runtime, genres
+	FROM movies
+	WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+	FOR UPDATE`
+
+	var snapshot MovieSnapshot
+
+	err := tx.QueryRowContext(ctx, stmt, id, version).Scan(
+		&snapshot.Title,
+		&snapshot.Year,
+		&snapshot.Runtime,
+		pq.Array(&snapshot.Genres),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return MovieSnapshot{}, ErrEditConflict
+		default:
+			return MovieSnapshot{}, err
+		}
+	}
+
+	return snapshot, nil
+}
+
+// updateLocked writes the movie locked by lockMovieVersion and records the
+// change as a revision.
+func updateLocked(ctx context.Context, tx *sql.Tx, movie *Movie, rev func(*Movie) *MovieRevision) error {
 	stmt := `
 	UPDATE movies
 	SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	WHERE id = $5
 	RETURNING version`
 
 	args := []interface{}{
@@ -142,45 +387,237 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Runtime,
 		pq.Array(movie.Genres),
 		movie.ID,
-		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	if err := tx.QueryRowContext(ctx, stmt, args...).Scan(&movie.Version); err != nil {
+		return err
+	}
+
+	return insertRevision(ctx, tx, rev(movie))
+}
+
+// Update updates a record with the movie arg passed, recording the change
+// as a revision on behalf of the user with userID.
+func (m MovieModel) Update(ctx context.Context, movie *Movie, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	prev, err := lockMovieVersion(ctx, tx, movie.ID, movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = updateLocked(ctx, tx, movie, func(movie *Movie) *MovieRevision {
+		return newRevision(movie, RevisionUpdate, prev, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Revert sets a movie's fields back to those it had at an earlier version,
+// as a new version. Like Update, it fails with ErrEditConflict unless the
+// movie is still at movie.Version, and ErrRecordNotFound when the movie
+// has no revision at version.
+func (m MovieModel) Revert(ctx context.Context, movie *Movie, version int32, userID int64) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	prev, err := lockMovieVersion(ctx, tx, movie.ID, movie.Version)
+	if err != nil {
+		return err
+	}
+
+	var snapshot []byte
+
+	err = tx.QueryRowContext(ctx, `SELECT snapshot FROM movie_revisions WHERE movie_id = $1 AND version = $2`, movie.ID, version).Scan(&snapshot)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return ErrRecordNotFound
 		default:
 			return err
 		}
 	}
-	return nil
+
+	var target MovieSnapshot
+	if err = json.Unmarshal(snapshot, &target); err != nil {
+		return err
+	}
+	movie.Title, movie.Year, movie.Runtime, movie.Genres = target.Title, target.Year, target.Runtime, target.Genres
+
+	err = updateLocked(ctx, tx, movie, func(movie *Movie) *MovieRevision {
+		rev := newRevision(movie, RevisionRevert, prev, userID)
+		rev.RevertedFrom = version
+		return rev
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// Delete deletes a specific movie record with the id
-func (m MovieModel) Delete(id int64) error {
-	stmt := `DELETE FROM movies WHERE id = $1`
+// setDeleted moves a movie into or out of the trash as a new version and
+// records the change as a revision. When version is not 0 the movie must
+// still be at that version, or setDeleted fails with ErrEditConflict.
+func (m MovieModel) setDeleted(ctx context.Context, id int64, deleted bool, version int32, userID int64) error {
+	stmt := `
+	UPDATE movies
+	SET deleted_at = CASE WHEN $2 THEN now() END, version = version + 1
+	WHERE id = $1 AND (deleted_at IS NULL) = $2 AND ($3 = 0 OR version = $3)
+	RETURNING title, year, runtime, genres, version, deleted_at`
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	resp, err := m.DB.ExecContext(ctx, stmt, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rows, err := resp.RowsAffected()
-	if rows == 0 {
-		return ErrRecordNotFound
+	movie := Movie{ID: id}
+
+	err = tx.QueryRowContext(ctx, stmt, id, deleted, version).Scan(
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.DeletedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && version != 0:
+			return ErrEditConflict
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	action, prev := RevisionDelete, snapshotMovie(&movie)
+	prev.Deleted = !deleted
+	if !deleted {
+		action = RevisionRestore
+	}
+
+	if err = insertRevision(ctx, tx, newRevision(&movie, action, prev, userID)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete moves a specific movie record to the trash, from which it can be
+// restored until Purge removes it. A non-zero version makes the delete
+// conditional on the movie still being at that version.
+func (m MovieModel) Delete(ctx context.Context, id int64, version int32, userID int64) error {
+	return m.setDeleted(ctx, id, true, version, userID)
+}
+
+// Restore takes a specific movie record out of the trash.
+func (m MovieModel) Restore(ctx context.Context, id, userID int64) error {
+	return m.setDeleted(ctx, id, false, 0, userID)
+}
+
+// GetDeleted returns a page of the movies in the trash, most recently
+// deleted first.
+func (m MovieModel) GetDeleted(ctx context.Context, filters Filters) ([]*Movie, Metadata, error) {
+	stmt := `
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, average_rating, rating_count, deleted_at
+		FROM movies
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC
+		LIMIT $1 OFFSET $2`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Purge permanently deletes the movies that went into the trash before
+// cutoff, along with their credits and reviews, returning how many went.
+// Their revisions are kept, closed by a final purge revision.
+func (m MovieModel) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	stmt := `
+	WITH purged AS (
+		DELETE FROM movies WHERE deleted_at < $1
+		RETURNING id, version, title, year, runtime, genres
+	)
+	INSERT INTO movie_revisions (movie_id, version, action, changed_fields, snapshot)
+	SELECT id, version + 1, $2, '{}',
+		jsonb_build_object(
+			'title', title,
+			'year', year,
+			'runtime', runtime || ' mins',
+			'genres', to_jsonb(genres),
+			'deleted', true
+		)
+	FROM purged`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	resp, err := m.DB.ExecContext(ctx, stmt, cutoff, RevisionPurge)
+	if err != nil {
+		return 0, err
 	}
 
-	return err
+	return resp.RowsAffected()
 }
 
 // ValidateMovie sanity-checks the movie JSON values provided.
@@ -0,0 +1,330 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lighten/internal/validator"
+)
+
+// Credit roles a person can hold on a movie.
+const (
+	RoleActor           = "actor"
+	RoleDirector        = "director"
+	RoleWriter          = "writer"
+	RoleProducer        = "producer"
+	RoleComposer        = "composer"
+	RoleCinematographer = "cinematographer"
+	RoleEditor          = "editor"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+
+	CreditRoles = []string{
+		RoleActor, RoleDirector, RoleWriter, RoleProducer,
+		RoleComposer, RoleCinematographer, RoleEditor,
+	}
+)
+
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitempty"`
+	Biography string    `json:"biography,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// Credit links a person to a movie in a given role. Name carries the
+// person's name on reads so clients don't need a second lookup.
+type Credit struct {
+	ID        int64  `json:"id"`
+	MovieID   int64  `json:"movie_id"`
+	PersonID  int64  `json:"person_id"`
+	Name      string `json:"name,omitempty"`
+	Role      string `json:"role"`
+	Character string `json:"character,omitempty"`
+}
+
+// ValidatePerson sanity-checks the person JSON values provided.
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	if person.BirthYear != 0 {
+		v.Check(person.BirthYear >= 1800, "birth_year", "must be greater or equal to 1800")
+		v.Check(person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+
+	v.Check(len(person.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+}
+
+// ValidateCredit sanity-checks the credit JSON values provided.
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoles...), "role", "must be a known credit role")
+
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	if credit.Role != RoleActor {
+		v.Check(credit.Character == "", "character", "must only be provided for actors")
+	}
+}
+
+// PersonModel wraps the sql.DB connection pool.
+type PersonModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert inserts a new person record into the people table.
+func (m PersonModel) Insert(ctx context.Context, person *Person) error {
+	stmt := `
+		INSERT INTO people (name, birth_year, biography)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+	args := []interface{}{person.Name, person.BirthYear, person.Biography}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, stmt, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+// Get fetches a specific person record with the id
+func (m PersonModel) Get(ctx context.Context, id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	stmt := `
+		SELECT id, created_at, name, birth_year, biography, version
+		FROM people
+		WHERE id = $1`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var person Person
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Biography,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// GetAll gets all the people whose name matches the query_string.
+func (m PersonModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error) {
+	stmt := fmt.Sprintf(
+		`
+		SELECT count(*) OVER(), id, created_at, name, birth_year, biography, version
+		FROM people
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection(),
+	)
+	args := []interface{}{name, filters.limit(), filters.offset()}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Biography,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return people, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Update updates a record with the person arg passed.
+func (m PersonModel) Update(ctx context.Context, person *Person) error {
+	stmt := `
+	UPDATE people
+	SET name = $1, birth_year = $2, biography = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version`
+
+	args := []interface{}{
+		person.Name,
+		person.BirthYear,
+		person.Biography,
+		person.ID,
+		person.Version,
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes a specific person record, along with their credits.
+func (m PersonModel) Delete(ctx context.Context, id int64) error {
+	stmt := `DELETE FROM people WHERE id = $1`
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	resp, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := resp.RowsAffected()
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return err
+}
+
+// AddCredit records a person's role on a movie.
+func (m PersonModel) AddCredit(ctx context.Context, credit *Credit) error {
+	// Movies in the trash are reported as not found.
+	stmt := `
+		INSERT INTO movie_credits (movie_id, person_id, role, character)
+		SELECT $1, $2, $3, $4
+		WHERE EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)
+		RETURNING id`
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&credit.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_credits_movie_id_person_id_role_character_key"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetCreditsForMovie returns the cast and crew of a movie, directors
+// first and then in the order they were credited.
+func (m PersonModel) GetCreditsForMovie(ctx context.Context, movieID int64) ([]*Credit, error) {
+	stmt := `
+		SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+		movie_credits.role, movie_credits.character
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = $1
+		ORDER BY movie_credits.role <> 'director', movie_credits.id`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// DeleteCredit removes a credit from a movie.
+func (m PersonModel) DeleteCredit(ctx context.Context, movieID, creditID int64) error {
+	stmt := `DELETE FROM movie_credits WHERE id = $1 AND movie_id = $2`
+	if creditID < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	resp, err := m.DB.ExecContext(ctx, stmt, creditID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := resp.RowsAffected()
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return err
+}
@@ -21,19 +21,36 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionsModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+	Cache   *LookupCache
 }
 
-// GetAllForUser returns all the permission a user has.
-func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
+// GetAllForUser returns all the permission a user has, whether granted
+// directly or through one of their roles.
+func (m PermissionsModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	stmt := `
 	SELECT permissions.code 
 	FROM permissions 
-	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id 
-	INNER JOIN users ON users_permissions.user_id = users.id 
-	WHERE users.id = $1`
+	WHERE permissions.id IN (
+		SELECT users_permissions.permission_id 
+		FROM users_permissions 
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT roles_permissions.permission_id 
+		FROM roles_permissions 
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id 
+		WHERE users_roles.user_id = $1
+	)
+	ORDER BY permissions.code`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	var permissions Permissions
+
+	if m.Cache.get(ctx, permissionsCacheKey(userID), &permissions) {
+		return permissions, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, stmt, userID)
@@ -42,8 +59,6 @@ func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 	}
 	defer rows.Close()
 
-	var permissions Permissions
-
 	for rows.Next() {
 		var permission string
 
@@ -58,18 +73,76 @@ func (m PermissionsModel) GetAllForUser(userID int64) (Permissions, error) {
 		return nil, err
 	}
 
+	m.Cache.set(ctx, permissionsCacheKey(userID), permissions, time.Time{})
+
 	return permissions, nil
 }
 
 // AddForUser records database read and write access/permissions for a user.
-func (m PermissionsModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionsModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	stmt := `
 	INSERT INTO users_permissions 
-	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, stmt, userID, pq.Array(codes))
-	return err
+	if err != nil {
+		return err
+	}
+
+	return m.Cache.invalidate(ctx, permissionsCacheKey(userID))
+}
+
+// RemoveForUser revokes the given permissions from a user.
+func (m PermissionsModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	stmt := `
+	DELETE FROM users_permissions 
+	USING permissions 
+	WHERE users_permissions.permission_id = permissions.id 
+	AND users_permissions.user_id = $1 AND permissions.code = ANY($2)`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	return m.Cache.invalidate(ctx, permissionsCacheKey(userID))
+}
+
+// GetAll returns every permission code that can be granted.
+func (m PermissionsModel) GetAll(ctx context.Context) (Permissions, error) {
+	stmt := `SELECT code FROM permissions ORDER BY code`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
 }
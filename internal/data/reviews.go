@@ -0,0 +1,277 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lighten/internal/validator"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Review is a user's rating of a movie, with an optional text review.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Rating    int32     `json:"rating"`
+	Text      string    `json:"text,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// ValidateReview sanity-checks the review JSON values provided.
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating != 0, "rating", "must be provided")
+	v.Check(review.Rating >= 1 && review.Rating <= 10, "rating", "must be between 1 and 10")
+
+	v.Check(len(review.Text) <= 10_000, "text", "must not be more than 10000 bytes long")
+}
+
+// ReviewModel wraps the sql.DB connection pool.
+type ReviewModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// lockMovie locks a movie row for the rest of the transaction, so rating
+// aggregates are recomputed one review change at a time. Movies in the
+// trash are reported as not found, so their reviews can't change.
+func lockMovie(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	var id int64
+
+	err := tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, movieID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// refreshMovieRating recomputes the average_rating and rating_count of a movie.
+func refreshMovieRating(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	stmt := `
+	UPDATE movies
+	SET (average_rating, rating_count) = (
+		SELECT COALESCE(round(avg(rating), 2), 0), count(*)
+		FROM reviews
+		WHERE movie_id = $1
+	)
+	WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, stmt, movieID)
+	return err
+}
+
+// Insert inserts a new review and updates the movie's rating aggregates.
+func (m ReviewModel) Insert(ctx context.Context, review *Review) error {
+	stmt := `
+	INSERT INTO reviews (movie_id, user_id, rating, text)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, updated_at, version`
+
+	args := []interface{}{review.MovieID, review.UserID, review.Rating, review.Text}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockMovie(ctx, tx, review.MovieID); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, stmt, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	if err = refreshMovieRating(ctx, tx, review.MovieID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get fetches a specific review of a movie.
+func (m ReviewModel) Get(ctx context.Context, movieID, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	stmt := `
+	SELECT id, created_at, updated_at, movie_id, user_id, rating, text, version
+	FROM reviews
+	WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var review Review
+
+	err := m.DB.QueryRowContext(ctx, stmt, id, movieID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Text,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForMovie returns a page of the reviews of a movie.
+func (m ReviewModel) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	stmt := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, updated_at, movie_id, user_id, rating, text, version
+	FROM reviews
+	WHERE movie_id = $1
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Text,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Update updates a review, guarding against concurrent edits with its
+// version number, and refreshes the movie's rating aggregates.
+func (m ReviewModel) Update(ctx context.Context, review *Review) error {
+	stmt := `
+	UPDATE reviews
+	SET rating = $1, text = $2, updated_at = NOW(), version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING updated_at, version`
+
+	args := []interface{}{review.Rating, review.Text, review.ID, review.Version}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockMovie(ctx, tx, review.MovieID); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, stmt, args...).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if err = refreshMovieRating(ctx, tx, review.MovieID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete deletes a review of a movie and refreshes the movie's rating aggregates.
+func (m ReviewModel) Delete(ctx context.Context, movieID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = lockMovie(ctx, tx, movieID); err != nil {
+		return err
+	}
+
+	resp, err := tx.ExecContext(ctx, `DELETE FROM reviews WHERE id = $1 AND movie_id = $2`, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := resp.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	if err = refreshMovieRating(ctx, tx, movieID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
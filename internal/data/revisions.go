@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Movie revision actions.
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+	RevisionPurge   = "purge"
+)
+
+// MovieSnapshot is the state of a movie as of one revision.
+type MovieSnapshot struct {
+	Title   string   `json:"title"`
+	Year    int32    `json:"year"`
+	Runtime Runtime  `json:"runtime"`
+	Genres  []string `json:"genres"`
+	Deleted bool     `json:"deleted"`
+}
+
+// MovieRevision records one change to a movie: the movie as it was left,
+// the fields that changed and the user who changed it. Every change bumps
+// the movie's version, so a movie has one revision per version.
+type MovieRevision struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	MovieID       int64     `json:"movie_id"`
+	Version       int32     `json:"version"`
+	Action        string    `json:"action"`
+	ChangedFields []string  `json:"changed_fields"`
+	// UserID is nil once the user who made the change has been deleted.
+	UserID *int64 `json:"user_id"`
+	// RevertedFrom is the version a revert restored.
+	RevertedFrom int32         `json:"reverted_from,omitempty"`
+	Movie        MovieSnapshot `json:"movie"`
+}
+
+// snapshotMovie captures the fields of a movie kept in its history.
+func snapshotMovie(movie *Movie) MovieSnapshot {
+	return MovieSnapshot{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  append([]string{}, movie.Genres...),
+		Deleted: movie.DeletedAt != nil,
+	}
+}
+
+// changedFields lists the fields that differ between two snapshots.
+func changedFields(prev, next MovieSnapshot) []string {
+	changed := []string{}
+
+	if prev.Title != next.Title {
+		changed = append(changed, "title")
+	}
+	if prev.Year != next.Year {
+		changed = append(changed, "year")
+	}
+	if prev.Runtime != next.Runtime {
+		changed = append(changed, "runtime")
+	}
+	if !equalStrings(prev.Genres, next.Genres) {
+		changed = append(changed, "genres")
+	}
+	if prev.Deleted != next.Deleted {
+		changed = append(changed, "deleted")
+	}
+
+	return changed
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newRevision builds the revision recording that movie reached its current
+// version through action, taken by the user with userID (0 for none).
+func newRevision(movie *Movie, action string, prev MovieSnapshot, userID int64) *MovieRevision {
+	rev := &MovieRevision{
+		MovieID: movie.ID,
+		Version: movie.Version,
+		Action:  action,
+		Movie:   snapshotMovie(movie),
+	}
+
+	if action == RevisionCreate {
+		rev.ChangedFields = []string{"title", "year", "runtime", "genres"}
+	} else {
+		rev.ChangedFields = changedFields(prev, rev.Movie)
+	}
+
+	if userID != 0 {
+		rev.UserID = &userID
+	}
+
+	return rev
+}
+
+// insertRevision stores a revision in the same transaction as the change
+// it records.
+func insertRevision(ctx context.Context, tx *sql.Tx, rev *MovieRevision) error {
+	stmt := `
+	INSERT INTO movie_revisions (movie_id, version, action, changed_fields, user_id, reverted_from, snapshot)
+	VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7)
+	RETURNING id, created_at`
+
+	snapshot, err := json.Marshal(rev.Movie)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{rev.MovieID, rev.Version, rev.Action, pq.Array(rev.ChangedFields), rev.UserID, rev.RevertedFrom, snapshot}
+
+	return tx.QueryRowContext(ctx, stmt, args...).Scan(&rev.ID, &rev.CreatedAt)
+}
+
+// MovieRevisionModel wraps the sql.DB connection pool. Revisions are
+// written by MovieModel alongside the changes they record.
+type MovieRevisionModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// scanRevision reads the columns selected by the revision queries.
+func scanRevision(scan func(dest ...interface{}) error, rev *MovieRevision, extra ...interface{}) error {
+	var (
+		snapshot     []byte
+		userID       sql.NullInt64
+		revertedFrom sql.NullInt32
+	)
+
+	dest := append(extra,
+		&rev.ID,
+		&rev.CreatedAt,
+		&rev.MovieID,
+		&rev.Version,
+		&rev.Action,
+		pq.Array(&rev.ChangedFields),
+		&userID,
+		&revertedFrom,
+		&snapshot,
+	)
+	if err := scan(dest...); err != nil {
+		return err
+	}
+
+	if userID.Valid {
+		rev.UserID = &userID.Int64
+	}
+	rev.RevertedFrom = revertedFrom.Int32
+
+	return json.Unmarshal(snapshot, &rev.Movie)
+}
+
+// GetAllForMovie returns a page of a movie's revisions, newest first.
+func (m MovieRevisionModel) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	stmt := `
+	SELECT count(*) OVER(), id, created_at, movie_id, version, action, changed_fields, user_id, reverted_from, snapshot
+	FROM movie_revisions
+	WHERE movie_id = $1
+	ORDER BY version DESC
+	LIMIT $2 OFFSET $3`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var rev MovieRevision
+		if err := scanRevision(rows.Scan, &rev, &totalRecords); err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &rev)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return revisions, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Get fetches the revision of a movie at a specific version.
+func (m MovieRevisionModel) Get(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	stmt := `
+	SELECT id, created_at, movie_id, version, action, changed_fields, user_id, reverted_from, snapshot
+	FROM movie_revisions
+	WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var rev MovieRevision
+
+	err := scanRevision(m.DB.QueryRowContext(ctx, stmt, movieID, version).Scan, &rev)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rev, nil
+}
@@ -0,0 +1,403 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lighten/internal/validator"
+)
+
+var (
+	ErrDuplicateRole = errors.New("duplicate role")
+
+	RoleNameRX = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+)
+
+// Role bundles permission codes under a name that can be assigned to users.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Permissions Permissions `json:"permissions"`
+	Version     int32       `json:"version"`
+}
+
+// ValidateRole sanity-checks the role JSON values provided. Whether the
+// permission codes exist is checked against the store by the caller.
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(role.Name, RoleNameRX), "name", "must only contain lowercase letters, digits, '-' and '_'")
+
+	v.Check(len(role.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+}
+
+// RoleModel wraps the sql.DB connection pool.
+type RoleModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+	Cache   *LookupCache
+}
+
+// roleHolderKeys returns the permission cache keys of every user holding
+// a role, which go stale when the role changes.
+func roleHolderKeys(ctx context.Context, tx *sql.Tx, roleID int64) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT user_id FROM users_roles WHERE role_id = $1`, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+
+	for rows.Next() {
+		var userID int64
+
+		err := rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, permissionsCacheKey(userID))
+	}
+
+	return keys, rows.Err()
+}
+
+// setRolePermissions replaces the permission codes bundled by a role.
+func setRolePermissions(ctx context.Context, tx *sql.Tx, roleID int64, codes Permissions) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM roles_permissions WHERE role_id = $1`, roleID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+	INSERT INTO roles_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, stmt, roleID, pq.Array([]string(codes)))
+	return err
+}
+
+// Insert inserts a new role along with the permission codes it bundles.
+func (m RoleModel) Insert(ctx context.Context, role *Role) error {
+	stmt := `
+	INSERT INTO roles (name, description)
+	VALUES ($1, $2)
+	RETURNING id, version`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, stmt, role.Name, role.Description).Scan(&role.ID, &role.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRole
+		default:
+			return err
+		}
+	}
+
+	if err = setRolePermissions(ctx, tx, role.ID, role.Permissions); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// roleColumns selects a role with its permission codes aggregated into an array.
+const roleColumns = `
+	roles.id, roles.name, roles.description, roles.version,
+	array(
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		WHERE roles_permissions.role_id = roles.id
+		ORDER BY permissions.code
+	)`
+
+// Get fetches a specific role by its id.
+func (m RoleModel) Get(ctx context.Context, id int64) (*Role, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.getWhere(ctx, `roles.id = $1`, id)
+}
+
+// GetByName fetches a specific role by its name.
+func (m RoleModel) GetByName(ctx context.Context, name string) (*Role, error) {
+	return m.getWhere(ctx, `roles.name = $1`, name)
+}
+
+func (m RoleModel) getWhere(ctx context.Context, where string, arg interface{}) (*Role, error) {
+	stmt := `SELECT ` + roleColumns + ` FROM roles WHERE ` + where
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var role Role
+
+	err := m.DB.QueryRowContext(ctx, stmt, arg).Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.Version,
+		pq.Array((*[]string)(&role.Permissions)),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &role, nil
+}
+
+// GetAll returns every role, ordered by name.
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	stmt := `SELECT ` + roleColumns + ` FROM roles ORDER BY roles.name`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&role.Version,
+			pq.Array((*[]string)(&role.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Update updates a role and replaces its permission codes, guarding
+// against concurrent edits with its version number.
+func (m RoleModel) Update(ctx context.Context, role *Role) error {
+	stmt := `
+	UPDATE roles
+	SET name = $1, description = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+
+	args := []interface{}{role.Name, role.Description, role.ID, role.Version}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, stmt, args...).Scan(&role.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRole
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if err = setRolePermissions(ctx, tx, role.ID, role.Permissions); err != nil {
+		return err
+	}
+
+	keys, err := roleHolderKeys(ctx, tx, role.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return m.Cache.invalidate(ctx, keys...)
+}
+
+// Delete deletes a role, unassigning it from every user that held it.
+func (m RoleModel) Delete(ctx context.Context, id int64) error {
+	stmt := `DELETE FROM roles WHERE id = $1`
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	keys, err := roleHolderKeys(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	resp, err := tx.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := resp.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return m.Cache.invalidate(ctx, keys...)
+}
+
+// GetHolders returns the IDs of the users a role is assigned to.
+func (m RoleModel) GetHolders(ctx context.Context, id int64) ([]int64, error) {
+	stmt := `SELECT user_id FROM users_roles WHERE role_id = $1 ORDER BY user_id`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []int64{}
+
+	for rows.Next() {
+		var userID int64
+
+		err := rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+// GetAllForUser returns the names of the roles assigned to a user.
+func (m RoleModel) GetAllForUser(ctx context.Context, userID int64) ([]string, error) {
+	stmt := `
+	SELECT roles.name
+	FROM roles
+	INNER JOIN users_roles ON users_roles.role_id = roles.id
+	WHERE users_roles.user_id = $1
+	ORDER BY roles.name`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// AddForUser assigns the named roles to a user. Unknown names are ignored.
+func (m RoleModel) AddForUser(ctx context.Context, userID int64, names ...string) error {
+	stmt := `
+	INSERT INTO users_roles
+	SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+	ON CONFLICT DO NOTHING`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	return m.Cache.invalidate(ctx, permissionsCacheKey(userID))
+}
+
+// RemoveForUser unassigns the named roles from a user.
+func (m RoleModel) RemoveForUser(ctx context.Context, userID int64, names ...string) error {
+	stmt := `
+	DELETE FROM users_roles
+	USING roles
+	WHERE users_roles.role_id = roles.id
+	AND users_roles.user_id = $1 AND roles.name = ANY($2)`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	return m.Cache.invalidate(ctx, permissionsCacheKey(userID))
+}
@@ -6,6 +6,8 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/lighten/internal/validator"
@@ -14,15 +16,37 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
-	ScopePasswordReset = "password-reset"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeUnlock         = "unlock"
 )
 
+// ErrTokenReused is returned when a refresh token that was already
+// exchanged is presented again, which suggests it has been stolen.
+var ErrTokenReused = errors.New("token reused")
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// Family ties the authentication and refresh tokens of one login
+	// session together, so the whole session can be revoked at once.
+	Family string `json:"-"`
+	Used   bool   `json:"-"`
+}
+
+// NewTokenFamily returns a random identifier for a new login session.
+func NewTokenFamily() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
 }
 
 // generateToken cryptographically secure random value for user activation
@@ -55,26 +79,41 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+	Cache   *LookupCache
 }
 
-func (m *TokenModel) New(userID int64, lifeSpan time.Duration, scope string) (*Token, error) {
+// New generates a token and records it on the tokens table.
+func (m TokenModel) New(ctx context.Context, userID int64, lifeSpan time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, lifeSpan, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// NewInFamily generates a token belonging to a login session.
+func (m TokenModel) NewInFamily(ctx context.Context, userID int64, lifeSpan time.Duration, scope, family string) (*Token, error) {
+	token, err := generateToken(userID, lifeSpan, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = family
+
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
 // Insert add a new token record on the tokens table.
-func (m TokenModel) Insert(token *Token) error {
-	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope) VALUES ($1, $2, $3, $4)`
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope, family) VALUES ($1, $2, $3, $4, $5)`
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.Family}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, stmt, args...)
@@ -82,14 +121,125 @@ func (m TokenModel) Insert(token *Token) error {
 }
 
 // DeleteAllForUser deletes all tokens for a specific user and scope.
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	stmt := `
 	DELETE FROM tokens 
 	WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.deleteTokens(ctx, stmt, scope, userID)
+	return err
+}
+
+// deleteTokens runs a DELETE statement on the tokens table and evicts the
+// deleted tokens from the cache. It returns the number of tokens deleted.
+func (m TokenModel) deleteTokens(ctx context.Context, stmt string, args ...interface{}) (int, error) {
+	rows, err := m.DB.QueryContext(ctx, stmt+` RETURNING hash, scope`, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var keys []string
+
+	for rows.Next() {
+		var hash []byte
+		var scope string
+
+		err := rows.Scan(&hash, &scope)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, tokenCacheKey(scope, hash))
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return len(keys), m.Cache.invalidate(ctx, keys...)
+}
+
+// Consume marks an unexpired token as used and returns it. A token that
+// exists but was already used yields ErrTokenReused along with the token,
+// so the caller can revoke its family.
+func (m TokenModel) Consume(ctx context.Context, scope, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	stmt := `
+	UPDATE tokens
+	SET used = true
+	WHERE hash = $1 AND scope = $2 AND expiry > $3 AND NOT used
+	RETURNING user_id, expiry, family`
+
+	token := &Token{Hash: tokenHash[:], Scope: scope, Used: true}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, stmt, token.Hash, scope, time.Now()).Scan(&token.UserID, &token.Expiry, &token.Family)
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// The token is missing, expired or already used; only the last case
+	// matters for reuse detection.
+	stmt = `
+	SELECT user_id, expiry, family
+	FROM tokens
+	WHERE hash = $1 AND scope = $2 AND used`
+
+	err = m.DB.QueryRowContext(ctx, stmt, token.Hash, scope).Scan(&token.UserID, &token.Expiry, &token.Family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return token, ErrTokenReused
+}
+
+// Revoke deletes a token, along with every other token of its login
+// session when it belongs to one.
+func (m TokenModel) Revoke(ctx context.Context, scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	stmt := `
+	DELETE FROM tokens
+	WHERE hash = $1 AND scope = $2
+	OR family IN (SELECT family FROM tokens WHERE hash = $1 AND scope = $2 AND family <> '')`
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	deleted, err := m.deleteTokens(ctx, stmt, tokenHash[:], scope)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteFamily deletes every token of a login session.
+func (m TokenModel) DeleteFamily(ctx context.Context, family string) error {
+	if family == "" {
+		return nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, stmt, scope, userID)
+	_, err := m.deleteTokens(ctx, `DELETE FROM tokens WHERE family = $1`, family)
 	return err
 }
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/lighten/internal/validator"
@@ -23,7 +24,10 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	// Language is the user's preferred language for emails, as a lowercase
+	// tag such as "fr" or "pt-br", or "" for the default.
+	Language string `json:"language"`
+	Version  int    `json:"-"`
 }
 
 type password struct {
@@ -68,6 +72,17 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// dummyPasswordHash is a bcrypt hash of no user's password, at the same
+// cost as Set uses.
+var dummyPasswordHash = []byte("$2a$12$/XMTfaPdfHECXVqLf4caD.UsYyZaLe.6WikyvWpm.0LCpHtqQWSl.")
+
+// SimulatePasswordCheck takes as long as checking a password with Matches,
+// so that a login with an unknown email can't be told apart from one with
+// a wrong password by how long it takes.
+func SimulatePasswordCheck(plaintextPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plaintextPassword))
+}
+
 // ValidateEmail sanity-check the provided user's email
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
@@ -81,12 +96,18 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 }
 
+// ValidateLanguage sanity-check the provided user's language tag
+func ValidateLanguage(v *validator.Validator, language string) {
+	v.Check(language == "" || validator.Matches(language, validator.LanguageRX), "language", "must be a language tag such as en or pt-br")
+}
+
 // ValidateUser sanity-check the provided user JSON
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
 
 	ValidateEmail(v, user.Email)
+	ValidateLanguage(v, user.Language)
 
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
@@ -99,21 +120,84 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 // Wraps the databse connection pool
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+	Cache   *LookupCache
 }
 
 // Insert inserts a new user record into the users table.
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return insertUser(ctx, m.DB, user)
+}
+
+// Register inserts a new user together with the named role, if any, an
+// activation token lasting activationTTL and the job built by welcome to
+// send it, in a single transaction, so that no account is left without
+// the email that activates it.
+func (m UserModel) Register(ctx context.Context, user *User, role string, activationTTL time.Duration, welcome func(token *Token) (*Job, error)) error {
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	if role != "" {
+		stmt := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = $2`
+
+		_, err = tx.ExecContext(ctx, stmt, user.ID, role)
+		if err != nil {
+			return err
+		}
+	}
+
+	token, err := generateToken(user.ID, activationTTL, ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope) VALUES ($1, $2, $3, $4)`
+
+	_, err = tx.ExecContext(ctx, stmt, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return err
+	}
+
+	job, err := welcome(token)
+	if err != nil {
+		return err
+	}
+
+	err = insertJob(ctx, tx, job)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertUser inserts a new user record through q.
+func insertUser(ctx context.Context, q querier, user *User) error {
 	stmt := `
-	INSERT INTO users (name, email, password_hash, activated) 
-	VALUES ($1, $2, $3, $4) 
+	INSERT INTO users (name, email, password_hash, activated, language) 
+	VALUES ($1, $2, $3, $4, $5) 
 	RETURNING id, created_at, version`
 
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.Language}
 
-	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := q.QueryRowContext(ctx, stmt, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -126,16 +210,54 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Get retrieves a specific user record with the id
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	stmt := `
+	SELECT id, created_at, name, email, password_hash, activated, language, version 
+	FROM users 
+	WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Language,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // GetByEmail retrieves a specific user record with the email
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	stmt := `
-	SELECT id, created_at, name, email, password_hash, activated, version 
+	SELECT id, created_at, name, email, password_hash, activated, language, version 
 	FROM users 
 	WHERE email = $1`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(
@@ -145,6 +267,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Language,
 		&user.Version,
 	)
 	if err != nil {
@@ -160,11 +283,11 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 }
 
 // Update updates a record with the user args passed.
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	stmt := `
 	UPDATE users 
-	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1 
-	WHERE id = $5 AND version = $6
+	SET name = $1, email = $2, password_hash = $3, activated = $4, language = $5, version = version + 1 
+	WHERE id = $6 AND version = $7
 	RETURNING version`
 
 	args := []interface{}{
@@ -172,17 +295,18 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Language,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "user_email_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -191,15 +315,40 @@ func (m UserModel) Update(user *User) error {
 		}
 	}
 
-	return nil
+	return m.Cache.invalidate(ctx, userCacheKey(user.ID))
 }
 
-// GetForToken retrieves a user record associated to a token.
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+// GetForToken retrieves a user record associated to a token. Lookups of
+// authentication tokens, made on every authenticated request, go through
+// the cache when one is configured, and return the user without their
+// password hash whether cached or not; such a user must be read again
+// with Get before it can be validated or updated.
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
+	cacheable := tokenScope == ScopeAuthentication
+	tokenKey := tokenCacheKey(tokenScope, tokenHash[:])
+
+	if cacheable {
+		var token cachedToken
+		var cached cachedUser
+		if m.Cache.get(ctx, tokenKey, &token) && time.Now().Before(token.Expiry) &&
+			m.Cache.get(ctx, userCacheKey(token.UserID), &cached) {
+			user := &User{
+				ID:        cached.ID,
+				CreatedAt: cached.CreatedAt,
+				Name:      cached.Name,
+				Email:     cached.Email,
+				Activated: cached.Activated,
+				Language:  cached.Language,
+				Version:   cached.Version,
+			}
+			return user, nil
+		}
+	}
+
 	stmt := `
-	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version 
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.language, users.version, tokens.expiry 
 	FROM users 
 	INNER JOIN tokens 
 	ON users.id = tokens.user_id 
@@ -208,8 +357,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
 	var user User
+	var expiry time.Time
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(
@@ -219,7 +369,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Language,
 		&user.Version,
+		&expiry,
 	)
 
 	if err != nil {
@@ -231,5 +383,100 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		}
 	}
 
+	if cacheable {
+		user.Password.hash = nil
+
+		m.Cache.set(ctx, tokenKey, cachedToken{UserID: user.ID, Expiry: expiry}, expiry)
+		m.Cache.set(ctx, userCacheKey(user.ID), cachedUser{
+			ID:        user.ID,
+			CreatedAt: user.CreatedAt,
+			Name:      user.Name,
+			Email:     user.Email,
+			Activated: user.Activated,
+			Language:  user.Language,
+			Version:   user.Version,
+		}, time.Time{})
+	}
+
 	return &user, nil
 }
+
+// GetAll searches users by name and email fragments and, when activated
+// is not nil, by activation status.
+func (m UserModel) GetAll(ctx context.Context, name, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	stmt := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, language, version
+	FROM users
+	WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+	AND (email ILIKE '%%' || $2 || '%%' OR $2 = '')
+	AND ($3::bool IS NULL OR activated = $3)
+	ORDER BY %s %s, id ASC
+	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{name, email, activated, filters.limit(), filters.offset()}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Language,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, calcMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Delete deletes a user record along with their tokens, permissions and reviews.
+func (m UserModel) Delete(ctx context.Context, id int64) error {
+	stmt := `DELETE FROM users WHERE id = $1`
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	resp, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := resp.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	// The user's tokens went with them, so cached token keys now resolve
+	// to a missing user and fall through to the database.
+	return m.Cache.invalidate(ctx, userCacheKey(id), permissionsCacheKey(id))
+}
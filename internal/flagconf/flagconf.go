@@ -0,0 +1,171 @@
+// Package flagconf layers a TOML config file and environment variables
+// beneath the flags of a flag.FlagSet, so that each setting is defined once,
+// as a flag, and resolved as defaults < file < environment < command line.
+package flagconf
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lighten/internal/validator"
+)
+
+// Loader resolves the flags of a FlagSet from every configuration source.
+type Loader struct {
+	FlagSet *flag.FlagSet
+	// EnvPrefix names the environment variable of each flag: with prefix
+	// "LIGHTEN", the flag "db-dsn" is read from LIGHTEN_DB_DSN.
+	EnvPrefix string
+	// CommandLineOnly lists flags the file and environment can't set,
+	// such as the path of the file itself. Print leaves them out.
+	CommandLineOnly []string
+	// Secrets lists flags whose values Print redacts.
+	Secrets []string
+
+	set map[string]bool
+}
+
+// EnvName returns the environment variable read for the named flag.
+func (l *Loader) EnvName(name string) string {
+	return l.EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func (l *Loader) commandLineOnly(name string) bool {
+	return validator.In(name, l.CommandLineOnly...)
+}
+
+// Load applies the settings of the TOML file at path, if path isn't empty,
+// then those of the environment, to every flag that wasn't given on the
+// command line, so it must be called after FlagSet.Parse. Problems are
+// added to v keyed by flag name, so that all of them can be reported at
+// once.
+func (l *Loader) Load(path string, v *validator.Validator) {
+	l.set = make(map[string]bool)
+
+	l.FlagSet.Visit(func(f *flag.Flag) {
+		l.set[f.Name] = true
+	})
+
+	settings := make(map[string][]string)
+
+	if path != "" {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			v.AddError("config", err.Error())
+			return
+		}
+
+		settings, err = parseTOML(string(src))
+		if err != nil {
+			v.AddError("config", fmt.Sprintf("%s: %s", path, err))
+			return
+		}
+
+		for name := range settings {
+			if l.FlagSet.Lookup(name) == nil || l.commandLineOnly(name) {
+				v.AddError(name, "is not a known setting")
+				delete(settings, name)
+			}
+		}
+	}
+
+	l.FlagSet.VisitAll(func(f *flag.Flag) {
+		if l.commandLineOnly(f.Name) {
+			return
+		}
+		if value, ok := os.LookupEnv(l.EnvName(f.Name)); ok {
+			settings[f.Name] = []string{value}
+		}
+	})
+
+	for name, values := range settings {
+		if l.set[name] {
+			continue
+		}
+
+		// Arrays set a flag once per element, which suits repeatable
+		// flags; any other flag keeps the last element.
+		for _, value := range values {
+			if err := l.FlagSet.Set(name, value); err != nil {
+				v.AddError(name, err.Error())
+				break
+			}
+		}
+		l.set[name] = true
+	}
+}
+
+// IsSet reports whether the named flag was set by any source, rather than
+// left at its default.
+func (l *Loader) IsSet(name string) bool {
+	return l.set[name]
+}
+
+// Print writes the effective settings to w as a TOML file that Load would
+// accept, grouping flags into tables by the part of their name before the
+// first hyphen. Secrets that are set are shown as "<redacted>".
+func (l *Loader) Print(w io.Writer) error {
+	tables := make(map[string][]*flag.Flag)
+
+	l.FlagSet.VisitAll(func(f *flag.Flag) {
+		if l.commandLineOnly(f.Name) {
+			return
+		}
+		table, _, _ := strings.Cut(f.Name, "-")
+		if table == f.Name {
+			table = ""
+		}
+		tables[table] = append(tables[table], f)
+	})
+
+	names := make([]string, 0, len(tables))
+	for table := range tables {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+
+	for i, table := range names {
+		if table != "" {
+			if i > 0 {
+				fmt.Fprintln(bw)
+			}
+			fmt.Fprintf(bw, "[%s]\n", table)
+		}
+
+		for _, f := range tables[table] {
+			key := f.Name
+			if table != "" {
+				key = strings.TrimPrefix(f.Name, table+"-")
+			}
+			fmt.Fprintf(bw, "%s = %s\n", key, l.formatValue(f))
+		}
+	}
+
+	return bw.Flush()
+}
+
+func (l *Loader) formatValue(f *flag.Flag) string {
+	if validator.In(f.Name, l.Secrets...) {
+		if l.IsSet(f.Name) || f.Value.String() != "" {
+			return strconv.Quote("<redacted>")
+		}
+		return strconv.Quote("")
+	}
+
+	if getter, ok := f.Value.(flag.Getter); ok {
+		switch getter.Get().(type) {
+		case bool, int, int64, uint, uint64, float64:
+			return f.Value.String()
+		}
+	}
+
+	return strconv.Quote(f.Value.String())
+}
@@ -0,0 +1,246 @@
+package flagconf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the subset of TOML a flat set of settings needs:
+// comments, [tables], bare and dotted keys, and values that are strings,
+// integers, floats, booleans or arrays of those. Table and key names are
+// joined with "-" and underscores become hyphens, so that
+//
+//	[db]
+//	max_open_conns = 25
+//
+// yields "db-max-open-conns". Every value is returned in the text form a
+// flag.Value expects; arrays yield one entry per element.
+func parseTOML(src string) (map[string][]string, error) {
+	settings := make(map[string][]string)
+
+	var table string
+
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: malformed table header", lineNo)
+			}
+			name, err := parseKey(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			table = name
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+
+		key, err := parseKey(line[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if table != "" {
+			key = table + "-" + key
+		}
+
+		value := strings.TrimSpace(line[eq+1:])
+
+		// An array may continue over the following lines until its
+		// brackets balance.
+		for strings.HasPrefix(value, "[") && !arrayClosed(value) && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+
+		values, err := parseValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", lineNo, key, err)
+		}
+
+		if _, exists := settings[key]; exists {
+			return nil, fmt.Errorf("line %d: %s is set more than once", lineNo, key)
+		}
+		settings[key] = values
+	}
+
+	return settings, nil
+}
+
+// parseKey joins the parts of a bare or dotted key with hyphens.
+func parseKey(s string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return "", fmt.Errorf("empty key in %q", s)
+		}
+		for _, r := range part {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+				return "", fmt.Errorf("invalid key %q", s)
+			}
+		}
+		parts[i] = strings.ReplaceAll(part, "_", "-")
+	}
+
+	return strings.Join(parts, "-"), nil
+}
+
+// stripComment removes a trailing # comment that isn't inside a string.
+func stripComment(line string) string {
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// arrayClosed reports whether the brackets opened in s are all closed.
+func arrayClosed(s string) bool {
+	depth := 0
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+
+	return depth <= 0
+}
+
+func parseValue(s string) ([]string, error) {
+	if strings.HasPrefix(s, "[") {
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		return parseArray(strings.TrimSpace(s[1 : len(s)-1]))
+	}
+
+	value, rest, err := parseScalar(s)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("unexpected %q after value", rest)
+	}
+
+	return []string{value}, nil
+}
+
+func parseArray(s string) ([]string, error) {
+	values := []string{}
+
+	for s != "" {
+		value, rest, err := parseScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("expected ',' between array elements")
+		}
+		s = strings.TrimSpace(rest[1:])
+	}
+
+	return values, nil
+}
+
+// parseScalar parses the value at the start of s, returning its text and
+// whatever follows it.
+func parseScalar(s string) (value, rest string, err error) {
+	if s == "" {
+		return "", "", fmt.Errorf("missing value")
+	}
+
+	switch s[0] {
+	case '"':
+		end := 1
+		for ; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		value, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", "", fmt.Errorf("invalid string %s", s[:end+1])
+		}
+		return value, s[end+1:], nil
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+
+	end := strings.IndexAny(s, ", \t]")
+	if end < 0 {
+		end = len(s)
+	}
+	value, rest = s[:end], s[end:]
+
+	switch {
+	case value == "true" || value == "false":
+		return value, rest, nil
+	case isNumber(value):
+		return strings.ReplaceAll(value, "_", ""), rest, nil
+	default:
+		return "", "", fmt.Errorf("invalid value %q; strings must be quoted", value)
+	}
+}
+
+func isNumber(s string) bool {
+	s = strings.ReplaceAll(s, "_", "")
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return true
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
@@ -0,0 +1,151 @@
+package flagconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    map[string][]string
+		wantErr string
+	}{
+		{
+			name: "empty",
+			src:  "\n# only a comment\n\n",
+			want: map[string][]string{},
+		},
+		{
+			name: "scalars",
+			src: `port = 4000
+env = "production"
+debug = false
+ratio = 0.5
+big = 1_000_000`,
+			want: map[string][]string{
+				"port":  {"4000"},
+				"env":   {"production"},
+				"debug": {"false"},
+				"ratio": {"0.5"},
+				"big":   {"1000000"},
+			},
+		},
+		{
+			name: "tables and underscores",
+			src: `[db]
+max_open_conns = 25
+[smtp]
+sender = "Greenlight <no-reply@greenlight.net>"`,
+			want: map[string][]string{
+				"db-max-open-conns": {"25"},
+				"smtp-sender":       {"Greenlight <no-reply@greenlight.net>"},
+			},
+		},
+		{
+			name: "dotted keys",
+			src:  `limiter.rps = 2`,
+			want: map[string][]string{"limiter-rps": {"2"}},
+		},
+		{
+			name: "comments",
+			src:  `dsn = "postgres://host/db#frag" # trailing comment`,
+			want: map[string][]string{"dsn": {"postgres://host/db#frag"}},
+		},
+		{
+			name: "literal and escaped strings",
+			src: `a = 'C:\path'
+b = "tab\there \"quoted\""`,
+			want: map[string][]string{
+				"a": {`C:\path`},
+				"b": {"tab\there \"quoted\""},
+			},
+		},
+		{
+			name: "arrays",
+			src: `origins = ["https://a.example", 'https://b.example']
+empty = []
+ports = [
+	80, # http
+	443,
+]`,
+			want: map[string][]string{
+				"origins": {"https://a.example", "https://b.example"},
+				"empty":   {},
+				"ports":   {"80", "443"},
+			},
+		},
+		{
+			name:    "unquoted string",
+			src:     `env = production`,
+			wantErr: `line 1: env: invalid value "production"`,
+		},
+		{
+			name:    "duplicate key",
+			src:     "[db]\ndsn = 'a'\n[db]\ndsn = 'b'",
+			wantErr: "line 4: db-dsn is set more than once",
+		},
+		{
+			name:    "missing equals",
+			src:     "port 4000",
+			wantErr: "line 1: expected key = value",
+		},
+		{
+			name:    "array of tables",
+			src:     "[[servers]]",
+			wantErr: "line 1: malformed table header",
+		},
+		{
+			name:    "invalid key",
+			src:     "po rt = 1",
+			wantErr: `line 1: invalid key "po rt "`,
+		},
+		{
+			name:    "empty key part",
+			src:     "db..dsn = 'a'",
+			wantErr: "line 1: empty key",
+		},
+		{
+			name:    "unterminated string",
+			src:     `env = "production`,
+			wantErr: "line 1: env: unterminated string",
+		},
+		{
+			name:    "unterminated array",
+			src:     "ports = [80,\n443",
+			wantErr: "line 1: ports: unterminated array",
+		},
+		{
+			name:    "trailing garbage",
+			src:     `port = 4000 4001`,
+			wantErr: `line 1: port: unexpected " 4001" after value`,
+		},
+		{
+			name:    "missing array separator",
+			src:     `ports = [80 443]`,
+			wantErr: "line 1: ports: expected ',' between array elements",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTOML(tt.src)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+// Package jobs runs background work through a durable queue stored by a
+// data.JobStore. Workers claim due jobs one at a time, retry failures with
+// exponential backoff and mark a job dead once it has used up its
+// attempts, so work survives restarts and crashes.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lighten/internal/data"
+	"github.com/lighten/internal/jsonlog"
+	"github.com/lighten/internal/tracing"
+)
+
+// Config tunes a Queue.
+type Config struct {
+	// Workers is the number of jobs run at once.
+	Workers int
+	// PollInterval is how long an idle worker waits before looking for due
+	// jobs again, unless a job is enqueued through this Queue sooner.
+	PollInterval time.Duration
+	// Timeout bounds a single attempt. A worker's lease on a job lasts
+	// twice as long, after which another worker may claim it.
+	Timeout time.Duration
+	// MaxAttempts is the number of attempts before a job is marked dead,
+	// unless its Type sets its own.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; each further retry
+	// waits twice as long as the previous one, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// RequestID returns the ID of the request that ctx belongs to, if any.
+	// Enqueue records it with the job, so the job's log entries can be tied
+	// to the request.
+	RequestID func(ctx context.Context) string
+}
+
+// permanentError marks an error that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error returned by a handler so the job is marked dead
+// straight away rather than retried.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type handler func(ctx context.Context, payload json.RawMessage) error
+
+// Queue enqueues jobs and runs them with the handlers registered for their
+// kind.
+type Queue struct {
+	store    data.JobStore
+	logger   *jsonlog.Logger
+	config   Config
+	handlers map[string]handler
+	// wake nudges an idle worker when a job is enqueued.
+	wake chan struct{}
+}
+
+// New returns a Queue storing its jobs in store.
+func New(store data.JobStore, logger *jsonlog.Logger, config Config) *Queue {
+	return &Queue{
+		store:    store,
+		logger:   logger,
+		config:   config,
+		handlers: make(map[string]handler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Type is a kind of job whose payload is a T, encoded as JSON.
+type Type[T any] struct {
+	Name string
+	// MaxAttempts overrides the queue's Config.MaxAttempts when not 0.
+	MaxAttempts int
+}
+
+// Handle registers fn to run the jobs of this type. It panics if the type
+// already has a handler.
+func (t Type[T]) Handle(q *Queue, fn func(ctx context.Context, payload T) error) {
+	if _, ok := q.handlers[t.Name]; ok {
+		panic("jobs: duplicate handler for " + t.Name)
+	}
+
+	q.handlers[t.Name] = func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+
+		// Keep numbers as json.Number so large IDs in loosely typed
+		// payloads aren't turned into floats.
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&payload); err != nil {
+			return Permanent(fmt.Errorf("decoding payload: %w", err))
+		}
+
+		return fn(ctx, payload)
+	}
+}
+
+// Enqueue adds a job of this type to the queue, to run as soon as a worker
+// is free. The job's attempts continue the trace of ctx.
+func (t Type[T]) Enqueue(ctx context.Context, q *Queue, payload T) error {
+	job, err := t.Job(ctx, q, payload)
+	if err != nil {
+		return err
+	}
+
+	err = q.store.Enqueue(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	q.Notify()
+
+	return nil
+}
+
+// Job builds a job of this type without enqueuing it, for a store method
+// that enqueues it as part of a larger transaction. Call Notify once that
+// transaction commits.
+func (t Type[T]) Job(ctx context.Context, q *Queue, payload T) (*data.Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &data.Job{Kind: t.Name, Payload: raw, MaxAttempts: t.MaxAttempts}
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = q.config.MaxAttempts
+	}
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		job.Traceparent = sc.Traceparent()
+	}
+	if q.config.RequestID != nil {
+		job.RequestID = q.config.RequestID(ctx)
+	}
+
+	return job, nil
+}
+
+// Notify wakes an idle worker to look for the jobs just enqueued, rather
+// than wait for the next poll.
+func (q *Queue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// kinds returns the kinds of job this queue has handlers for, so that
+// jobs added by a newer build are left for the instances that know them.
+func (q *Queue) kinds() []string {
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Run starts the workers and blocks until ctx is cancelled and every job
+// in progress has finished. Jobs in progress are not cancelled with ctx,
+// only bounded by Config.Timeout, so a shutdown drains them.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < q.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	kinds := q.kinds()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, err := q.store.Claim(ctx, kinds, 2*q.config.Timeout)
+		if err == nil {
+			q.run(job)
+			continue
+		}
+		if !errors.Is(err, data.ErrRecordNotFound) && ctx.Err() == nil {
+			q.logger.Error(fmt.Errorf("claiming job: %w", err))
+		}
+
+		timer := time.NewTimer(q.config.PollInterval)
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// run runs one attempt of a claimed job and records the outcome. The
+// attempt continues the trace the job was enqueued in, if any.
+func (q *Queue) run(job *data.Job) {
+	ctx := context.Background()
+	if sc, err := tracing.ParseTraceparent(job.Traceparent); err == nil {
+		ctx = tracing.ContextWithRemoteParent(ctx, sc)
+	}
+
+	ctx, span := tracing.StartKind(ctx, "job "+job.Kind, tracing.KindConsumer)
+	span.SetAttribute("job.id", job.ID)
+	span.SetAttribute("job.attempt", job.Attempts)
+	defer span.End()
+
+	fields := []jsonlog.Field{
+		jsonlog.String("job_kind", job.Kind),
+		jsonlog.Int("job_id", job.ID),
+		jsonlog.Int("attempt", int64(job.Attempts)),
+	}
+	if job.RequestID != "" {
+		fields = append(fields, jsonlog.String("request_id", job.RequestID))
+	}
+	if sc := span.SpanContext(); sc.IsValid() {
+		fields = append(fields,
+			jsonlog.String("trace_id", sc.TraceID.String()),
+			jsonlog.String("span_id", sc.SpanID.String()),
+		)
+	}
+
+	var err error
+	if job.Attempts > job.MaxAttempts {
+		// The job's lease ran out on its last attempt, most likely because
+		// it crashed or hung the worker running it.
+		err = Permanent(errors.New("lease expired on the last attempt"))
+	} else {
+		err = q.attempt(ctx, q.handlers[job.Kind], job)
+	}
+
+	// Record the outcome even if the attempt used up its timeout.
+	storeCtx, cancel := context.WithTimeout(tracing.Detach(ctx), q.config.Timeout)
+	defer cancel()
+
+	if err == nil {
+		if err := q.store.Complete(storeCtx, job.ID); err != nil {
+			q.logger.Error(fmt.Errorf("completing job: %w", err), fields...)
+		}
+		return
+	}
+	span.RecordError(err)
+
+	var permanent permanentError
+	if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+		q.logger.Error(fmt.Errorf("job failed for the last time: %w", err), fields...)
+		if err := q.store.Bury(storeCtx, job.ID, err.Error()); err != nil {
+			q.logger.Error(fmt.Errorf("burying job: %w", err), fields...)
+		}
+		return
+	}
+
+	retryAt := time.Now().Add(q.backoff(job.Attempts))
+	q.logger.Warn("job failed, will retry", append(fields, jsonlog.Err(err), jsonlog.Time("retry_at", retryAt))...)
+	if err := q.store.Reschedule(storeCtx, job.ID, retryAt, err.Error()); err != nil {
+		q.logger.Error(fmt.Errorf("rescheduling job: %w", err), fields...)
+	}
+}
+
+// attempt calls the job's handler, turning a panic into an error.
+func (q *Queue) attempt(ctx context.Context, h handler, job *data.Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, q.config.Timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return h(ctx, job.Payload)
+}
+
+// backoff returns the delay before retrying a job that failed its nth
+// attempt, with up to 20% jitter so that jobs failing together don't all
+// retry together.
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.config.Backoff
+	for i := 1; i < attempt && delay < q.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.config.MaxBackoff {
+		delay = q.config.MaxBackoff
+	}
+
+	return delay - time.Duration(rand.Int63n(int64(delay)/5+1))
+}
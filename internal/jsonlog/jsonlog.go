@@ -2,17 +2,22 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,49 +25,190 @@ const (
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
+// ParseLevel returns the level named s, case-insensitively.
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+
+	return LevelOff, fmt.Errorf("unknown log level %q", s)
+}
+
+// MarshalText encodes the level by name, for encoding/json and flag.TextVar.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText decodes a level name.
+func (l *Level) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+
+	return nil
+}
+
+// Options configures a Logger.
+type Options struct {
+	// MinLevel is the least severe level written.
+	MinLevel Level
+	// StackTraceLevel is the least severe level whose entries carry a
+	// stack trace. LevelOff disables stack traces.
+	StackTraceLevel Level
+	// Sampling, when set, limits how often an identical message is
+	// written; see Sampling.
+	Sampling *Sampling
+}
+
+// Sampling thins out high-volume messages: within each Tick, the First
+// entries with a given level and message are written, then only every
+// Thereafter-th one. Thereafter of 0 drops the rest of the tick.
+type Sampling struct {
+	Tick       time.Duration
+	First      int
+	Thereafter int
+}
+
+// sampler counts entries per level and message within the current tick.
+type sampler struct {
+	Sampling
+
+	mu     sync.Mutex
+	resets time.Time
+	counts map[string]int
+}
+
+func (s *sampler) allow(level Level, message string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.resets) {
+		s.counts = make(map[string]int)
+		s.resets = now.Add(s.Tick)
+	}
+
+	key := level.String() + "\x00" + message
+	s.counts[key]++
+	n := s.counts[key]
+
+	if n <= s.First {
+		return true
+	}
+	return s.Thereafter > 0 && (n-s.First)%s.Thereafter == 0
+}
+
+// core is the state shared by a Logger and the children derived from it
+// with With, so that a level change applies to all of them.
+type core struct {
+	out             io.Writer
+	mu              sync.Mutex
+	minLevel        int32
+	stackTraceLevel Level
+	sampler         *sampler
+}
+
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	core   *core
+	fields []Field
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
+	return NewWithOptions(out, Options{MinLevel: minLevel, StackTraceLevel: LevelError})
+}
+
+// NewWithOptions returns a Logger writing to out as configured by opts.
+func NewWithOptions(out io.Writer, opts Options) *Logger {
+	c := &core{
+		out:             out,
+		minLevel:        int32(opts.MinLevel),
+		stackTraceLevel: opts.StackTraceLevel,
+	}
+	if opts.Sampling != nil && opts.Sampling.Tick > 0 {
+		c.sampler = &sampler{Sampling: *opts.Sampling}
+	}
+
+	return &Logger{core: c}
+}
+
+// Level returns the least severe level currently written.
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.core.minLevel))
+}
+
+// SetLevel changes the least severe level written, for this logger and
+// every logger sharing its output.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.core.minLevel, int32(level))
+}
+
+// Enabled reports whether entries at level would be written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// With returns a child logger that adds fields to every entry.
+func (l *Logger) With(fields ...Field) *Logger {
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		core:   l.core,
+		fields: append(append([]Field{}, l.fields...), fields...),
 	}
 }
 
 // Print internalizes writing the log entry
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
+func (l *Logger) print(level Level, message string, fields []Field) (int, error) {
+	if !l.Enabled(level) {
 		return 0, nil
 	}
+	if l.core.sampler != nil && level < LevelError && !l.core.sampler.allow(level, message) {
+		return 0, nil
+	}
+
+	var properties map[string]interface{}
+	if len(l.fields)+len(fields) > 0 {
+		properties = make(map[string]interface{}, len(l.fields)+len(fields))
+		for _, f := range l.fields {
+			properties[f.Key] = f.Value
+		}
+		for _, f := range fields {
+			properties[f.Key] = f.Value
+		}
+	}
 
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string                 `json:"level"`
+		Time       string                 `json:"time"`
+		Message    string                 `json:"message"`
+		Properties map[string]interface{} `json:"properties,omitempty"`
+		Trace      string                 `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
 		Time:       time.Now().Format(time.RFC3339),
 		Message:    message,
 		Properties: properties,
 	}
-	if level >= LevelError {
+	if l.core.stackTraceLevel != LevelOff && level >= l.core.stackTraceLevel {
 		aux.Trace = string(debug.Stack())
 	}
 
@@ -72,25 +218,57 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+
+	return l.core.out.Write(append(line, '\n'))
+}
+
+// Debug emits log entries at a DEBUG level
+func (l *Logger) Debug(message string, fields ...Field) {
+	l.print(LevelDebug, message, fields)
+}
+
+// Info emits log entries at a INFO level
+func (l *Logger) Info(message string, fields ...Field) {
+	l.print(LevelInfo, message, fields)
+}
+
+// Warn emits log entries at a WARN level
+func (l *Logger) Warn(message string, fields ...Field) {
+	l.print(LevelWarn, message, fields)
+}
 
-	return l.out.Write(append(line, '\n'))
+// Error emits log entries at a ERROR level, with err as the message
+func (l *Logger) Error(err error, fields ...Field) {
+	l.print(LevelError, err.Error(), fields)
+}
+
+// Fatal emits log entries at a FATAL level and exits
+func (l *Logger) Fatal(err error, fields ...Field) {
+	l.print(LevelFatal, err.Error(), fields)
+	os.Exit(1)
 }
 
 // PrintInfo emits log entries at a INFO level
+//
+// Deprecated: use Info with typed fields.
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+	l.print(LevelInfo, message, Strings(properties))
 }
 
 // PrintError emits log entries at a ERROR level
+//
+// Deprecated: use Error with typed fields.
 func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+	l.print(LevelError, err.Error(), Strings(properties))
 }
 
 // PrintFatal emits log entries at a FATAL level
+//
+// Deprecated: use Fatal with typed fields.
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+	l.print(LevelFatal, err.Error(), Strings(properties))
 	os.Exit(1)
 }
 
@@ -98,3 +276,75 @@ func (l *Logger) PrintFatal(err error, properties map[string]string) {
 func (l *Logger) Write(message []byte) (n int, err error) {
 	return l.print(LevelError, string(message), nil)
 }
+
+// Field is a typed key-value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Strings returns a string field for each entry of properties, e.g. to
+// log a map of validation errors as an Object.
+func Strings(properties map[string]string) []Field {
+	fields := make([]Field, 0, len(properties))
+	for key, value := range properties {
+		fields = append(fields, String(key, value))
+	}
+	return fields
+}
+
+// String returns a string field.
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Int returns an integer field.
+func Int(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Float returns a floating point field.
+func Float(key string, value float64) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Bool returns a boolean field.
+func Bool(key string, value bool) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Duration returns a field holding d in its string form, e.g. "1.5ms".
+func Duration(key string, d time.Duration) Field {
+	return Field{Key: key, Value: d.String()}
+}
+
+// Time returns a field holding t in RFC 3339 format.
+func Time(key string, t time.Time) Field {
+	return Field{Key: key, Value: t.Format(time.RFC3339Nano)}
+}
+
+// Err returns an "error" field holding err's message, or null for nil.
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Value: nil}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+// Object returns a field holding the given fields as a nested object.
+func Object(key string, fields ...Field) Field {
+	object := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		object[f.Key] = f.Value
+	}
+	return Field{Key: key, Value: object}
+}
+
+// Any returns a field holding value as encoded by encoding/json. Errors
+// are logged by message, as encoding/json would encode most as {}.
+func Any(key string, value interface{}) Field {
+	if err, ok := value.(error); ok {
+		return Field{Key: key, Value: err.Error()}
+	}
+	return Field{Key: key, Value: value}
+}
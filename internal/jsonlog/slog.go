@@ -0,0 +1,141 @@
+//go:build go1.21
+
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler returns a slog.Handler that writes through l, so that code
+// logging with log/slog shares its output, level and bound fields. slog
+// levels map onto the nearest jsonlog level at or below them.
+func (l *Logger) Handler() slog.Handler {
+	return &slogHandler{logger: l}
+}
+
+// SetSlogDefault makes l the destination of the default slog logger, and
+// with it of the standard log package.
+func (l *Logger) SetSlogDefault() {
+	slog.SetDefault(slog.New(l.Handler()))
+}
+
+// slogGroup is a group opened with WithGroup, holding the attributes
+// bound inside it before the next group was opened.
+type slogGroup struct {
+	name   string
+	fields []Field
+}
+
+type slogHandler struct {
+	logger *Logger
+	groups []slogGroup
+}
+
+func fromSlogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Enabled(fromSlogLevel(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	var fields []Field
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendAttr(fields, a)
+		return true
+	})
+
+	// Nest the record's fields inside the open groups, innermost first.
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		group := h.groups[i]
+		inner := append(append([]Field{}, group.fields...), fields...)
+		if len(inner) == 0 {
+			fields = nil
+			continue
+		}
+		fields = []Field{Object(group.name, inner...)}
+	}
+
+	_, err := h.logger.print(fromSlogLevel(r.Level), r.Message, fields)
+	return err
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields []Field
+	for _, a := range attrs {
+		fields = appendAttr(fields, a)
+	}
+
+	if len(h.groups) == 0 {
+		return &slogHandler{logger: h.logger.With(fields...)}
+	}
+
+	groups := append([]slogGroup{}, h.groups...)
+	last := &groups[len(groups)-1]
+	last.fields = append(append([]Field{}, last.fields...), fields...)
+
+	return &slogHandler{logger: h.logger, groups: groups}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &slogHandler{
+		logger: h.logger,
+		groups: append(append([]slogGroup{}, h.groups...), slogGroup{name: name}),
+	}
+}
+
+// appendAttr converts a to a field as slog.JSONHandler would encode it:
+// empty attributes are dropped and groups without a key are inlined.
+func appendAttr(fields []Field, a slog.Attr) []Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	v := a.Value
+	switch v.Kind() {
+	case slog.KindGroup:
+		var group []Field
+		for _, ga := range v.Group() {
+			group = appendAttr(group, ga)
+		}
+		if len(group) == 0 {
+			return fields
+		}
+		if a.Key == "" {
+			return append(fields, group...)
+		}
+		return append(fields, Object(a.Key, group...))
+	case slog.KindString:
+		return append(fields, String(a.Key, v.String()))
+	case slog.KindInt64:
+		return append(fields, Int(a.Key, v.Int64()))
+	case slog.KindUint64:
+		return append(fields, Field{Key: a.Key, Value: v.Uint64()})
+	case slog.KindFloat64:
+		return append(fields, Float(a.Key, v.Float64()))
+	case slog.KindBool:
+		return append(fields, Bool(a.Key, v.Bool()))
+	case slog.KindDuration:
+		return append(fields, Duration(a.Key, v.Duration()))
+	case slog.KindTime:
+		return append(fields, Time(a.Key, v.Time()))
+	default:
+		return append(fields, Any(a.Key, v.Any()))
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !go1.21
+
+package jsonlog
+
+// SetSlogDefault does nothing before Go 1.21, which introduced log/slog.
+func (l *Logger) SetSlogDefault() {}
@@ -0,0 +1,59 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// Denylist remembers revoked token, session and user identifiers. Entries
+// only need to outlive the tokens they revoke, so they expire after ttl,
+// which should be at least the lifetime of issued tokens.
+type Denylist struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]time.Time
+}
+
+// NewDenylist returns an empty Denylist whose entries expire after ttl.
+func NewDenylist(ttl time.Duration) *Denylist {
+	return &Denylist{
+		ttl:     ttl,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// Revoke records that every token matching key and issued before now is
+// no longer valid. Times are kept to the millisecond, the precision of the
+// iat claim.
+func (d *Denylist) Revoke(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().Truncate(time.Millisecond)
+	d.entries[key] = now
+
+	// Sweep expired entries while the lock is held anyway.
+	for k, revokedAt := range d.entries {
+		if now.Sub(revokedAt) > d.ttl {
+			delete(d.entries, k)
+		}
+	}
+}
+
+// Revoked reports whether a token issued at issuedAt is revoked by key.
+func (d *Denylist) Revoked(key string, issuedAt time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	revokedAt, ok := d.entries[key]
+	if !ok {
+		return false
+	}
+
+	if time.Since(revokedAt) > d.ttl {
+		delete(d.entries, key)
+		return false
+	}
+
+	return issuedAt.Before(revokedAt)
+}
@@ -0,0 +1,224 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Supported signing algorithms.
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Claims is the payload carried by the tokens issued by the API.
+type Claims struct {
+	Issuer  string `json:"iss,omitempty"`
+	Subject string `json:"sub"`
+	ID      string `json:"jti"`
+	Session string `json:"sid,omitempty"`
+	// IssuedAt has millisecond precision, so that a token issued right
+	// after a revocation isn't mistaken for one issued before it.
+	IssuedAt    float64  `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+	Activated   bool     `json:"act"`
+	Permissions []string `json:"perms,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Key is a named signing key. HS256 keys hold a shared secret, EdDSA keys
+// an Ed25519 private key.
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+}
+
+// ParseKey parses a key description of the form "kid:alg:base64", where
+// the base64 part is the HS256 secret or the 32-byte Ed25519 seed.
+func ParseKey(spec string) (Key, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return Key{}, fmt.Errorf("jwt key %q must have the form kid:alg:base64", spec)
+	}
+
+	material, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Key{}, fmt.Errorf("jwt key %q: %w", parts[0], err)
+	}
+
+	key := Key{ID: parts[0], Algorithm: parts[1]}
+
+	switch key.Algorithm {
+	case AlgHS256:
+		if len(material) < 32 {
+			return Key{}, fmt.Errorf("jwt key %q: HS256 secrets must be at least 32 bytes", key.ID)
+		}
+		key.secret = material
+	case AlgEdDSA:
+		if len(material) != ed25519.SeedSize {
+			return Key{}, fmt.Errorf("jwt key %q: EdDSA seeds must be %d bytes", key.ID, ed25519.SeedSize)
+		}
+		key.private = ed25519.NewKeyFromSeed(material)
+		key.public = key.private.Public().(ed25519.PublicKey)
+	default:
+		return Key{}, fmt.Errorf("jwt key %q: unsupported algorithm %q", key.ID, key.Algorithm)
+	}
+
+	return key, nil
+}
+
+func (k Key) sign(signingInput []byte) []byte {
+	if k.Algorithm == AlgEdDSA {
+		return ed25519.Sign(k.private, signingInput)
+	}
+
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil)
+}
+
+func (k Key) verify(signingInput, signature []byte) bool {
+	if k.Algorithm == AlgEdDSA {
+		return ed25519.Verify(k.public, signingInput, signature)
+	}
+
+	return hmac.Equal(signature, k.sign(signingInput))
+}
+
+// Keyring signs tokens with its current key and verifies tokens signed by
+// any of its keys, which allows keys to be rotated through the kid header.
+type Keyring struct {
+	signing Key
+	keys    map[string]Key
+}
+
+// NewKeyring returns a Keyring that signs with the key named signingKeyID.
+func NewKeyring(signingKeyID string, keys ...Key) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]Key)}
+
+	for _, key := range keys {
+		if _, exists := k.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate jwt key id %q", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+
+	signing, ok := k.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("jwt signing key %q is not configured", signingKeyID)
+	}
+	k.signing = signing
+
+	return k, nil
+}
+
+// Sign encodes and signs the claims with the current signing key.
+func (k *Keyring) Sign(claims Claims) (string, error) {
+	h, err := json.Marshal(header{Algorithm: k.signing.Algorithm, Type: "JWT", KeyID: k.signing.ID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+	signature := k.signing.sign([]byte(signingInput))
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify checks the token's signature and expiry and returns its claims.
+func (k *Keyring) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	rawHeader, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := k.keys[h.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// The algorithm is pinned by the key, never chosen by the token.
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// NumericDate returns t as a JWT NumericDate with millisecond precision.
+func NumericDate(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
+
+// Issued returns the time the token was issued, to the millisecond.
+func (c *Claims) Issued() time.Time {
+	return time.UnixMilli(int64(math.Round(c.IssuedAt * 1000)))
+}
+
+// LooksLikeJWT reports whether a bearer token has the three-part shape of
+// a JWT, as opposed to an opaque database token.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,222 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustParseKey(t *testing.T, kid, alg string, material byte, size int) Key {
+	t.Helper()
+
+	spec := kid + ":" + alg + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(material), size)))
+	key, err := ParseKey(spec)
+	if err != nil {
+		t.Fatalf("ParseKey(%q): %v", spec, err)
+	}
+	return key
+}
+
+func mustKeyring(t *testing.T, signingKeyID string, keys ...Key) *Keyring {
+	t.Helper()
+
+	k, err := NewKeyring(signingKeyID, keys...)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
+func TestParseKey(t *testing.T) {
+	b64 := func(n int) string {
+		return base64.StdEncoding.EncodeToString(make([]byte, n))
+	}
+
+	tests := []struct {
+		name    string
+		spec    string
+		wantAlg string
+		wantErr string
+	}{
+		{"hs256", "k1:HS256:" + b64(32), AlgHS256, ""},
+		{"eddsa", "k2:EdDSA:" + b64(32), AlgEdDSA, ""},
+		{"missing parts", "k1:HS256", "", "must have the form kid:alg:base64"},
+		{"empty kid", ":HS256:" + b64(32), "", "must have the form kid:alg:base64"},
+		{"bad base64", "k1:HS256:***", "", "illegal base64"},
+		{"short secret", "k1:HS256:" + b64(16), "", "at least 32 bytes"},
+		{"wrong seed size", "k2:EdDSA:" + b64(64), "", "EdDSA seeds must be 32 bytes"},
+		{"unsupported algorithm", "k3:RS256:" + b64(32), "", `unsupported algorithm "RS256"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey(tt.spec)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v; want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if key.Algorithm != tt.wantAlg {
+				t.Errorf("got algorithm %q; want %q", key.Algorithm, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	k1 := mustParseKey(t, "k1", AlgHS256, 'a', 32)
+	k1Again := mustParseKey(t, "k1", AlgHS256, 'b', 32)
+
+	tests := []struct {
+		name    string
+		signing string
+		keys    []Key
+		wantErr string
+	}{
+		{"valid", "k1", []Key{k1}, ""},
+		{"duplicate kid", "k1", []Key{k1, k1Again}, `duplicate jwt key id "k1"`},
+		{"missing signing key", "k2", []Key{k1}, `jwt signing key "k2" is not configured`},
+		{"no keys", "k1", nil, `jwt signing key "k1" is not configured`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.signing, tt.keys...)
+
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("got error %q; want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	hs := mustParseKey(t, "hs", AlgHS256, 's', 32)
+	ed := mustParseKey(t, "ed", AlgEdDSA, 'e', 32)
+	other := mustParseKey(t, "other", AlgHS256, 'o', 32)
+
+	claims := Claims{
+		Subject:   "42",
+		ID:        "jti-1",
+		IssuedAt:  NumericDate(now),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		Activated: true,
+	}
+
+	sign := func(k *Keyring, c Claims) string {
+		token, err := k.Sign(c)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return token
+	}
+
+	verifier := mustKeyring(t, "hs", hs, ed)
+	hsToken := sign(mustKeyring(t, "hs", hs), claims)
+	edToken := sign(mustKeyring(t, "ed", ed), claims)
+	parts := strings.Split(hsToken, ".")
+
+	expired := claims
+	expired.ExpiresAt = now.Unix()
+
+	tamperedClaims := claims
+	tamperedClaims.Subject = "1"
+	tamperedPayload, _ := json.Marshal(tamperedClaims)
+
+	// A token claiming HS256 under the EdDSA key's kid, signed with the
+	// Ed25519 public key as an HMAC secret: the classic algorithm
+	// confusion attack.
+	confusedHeader, _ := json.Marshal(header{Algorithm: AlgHS256, Type: "JWT", KeyID: "ed"})
+	confusedInput := encoding.EncodeToString(confusedHeader) + "." + parts[1]
+	confused := confusedInput + "." + encoding.EncodeToString(Key{Algorithm: AlgHS256, secret: ed.public}.sign([]byte(confusedInput)))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"hs256", hsToken, nil},
+		{"eddsa", edToken, nil},
+		{"expired", sign(mustKeyring(t, "hs", hs), expired), ErrExpiredToken},
+		{"unknown kid", sign(mustKeyring(t, "other", other), claims), ErrUnknownKey},
+		{"tampered payload", parts[0] + "." + encoding.EncodeToString(tamperedPayload) + "." + parts[2], ErrInvalidToken},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + encoding.EncodeToString([]byte("forged")), ErrInvalidToken},
+		{"algorithm confusion", confused, ErrInvalidToken},
+		{"two parts", parts[0] + "." + parts[1], ErrInvalidToken},
+		{"bad header", "!!!." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"bad signature encoding", parts[0] + "." + parts[1] + ".!!!", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifier.Verify(tt.token, now)
+
+			if err != tt.wantErr {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got.Subject != claims.Subject {
+				t.Errorf("got subject %q; want %q", got.Subject, claims.Subject)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	claims := Claims{Subject: "42", ID: "jti-1", ExpiresAt: now.Add(time.Hour).Unix()}
+
+	oldKey := mustParseKey(t, "2023-01", AlgHS256, 'a', 32)
+	newKey := mustParseKey(t, "2023-02", AlgEdDSA, 'b', 32)
+
+	// Before, during and after the rotation: the new key is first added
+	// for verification only, then made the signing key, then the old key
+	// is retired.
+	before := mustKeyring(t, "2023-01", oldKey)
+	during := mustKeyring(t, "2023-02", oldKey, newKey)
+	after := mustKeyring(t, "2023-02", newKey)
+
+	oldToken, err := before.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := during.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ring    *Keyring
+		token   string
+		wantErr error
+	}{
+		{"old token, old ring", before, oldToken, nil},
+		{"new token, old ring", before, newToken, ErrUnknownKey},
+		{"old token, rotating ring", during, oldToken, nil},
+		{"new token, rotating ring", during, newToken, nil},
+		{"old token, retired key", after, oldToken, ErrUnknownKey},
+		{"new token, new ring", after, newToken, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.ring.Verify(tt.token, now); err != tt.wantErr {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,71 +2,176 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
+	"fmt"
 	"html/template"
-	"time"
+	"io/fs"
+	"sort"
+	"strings"
 
-	"github.com/go-mail/mail/v2"
+	"github.com/lighten/internal/tracing"
 )
 
 //go:embed "templates"
 var templateFS embed.FS
 
+// ErrUnknownTemplate is returned by Send for a template the Mailer doesn't
+// have.
+var ErrUnknownTemplate = errors.New("mailer: unknown template")
+
+// Message is a rendered email, as handed to a Transport.
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Transport delivers rendered messages.
+type Transport interface {
+	Send(ctx context.Context, msg Message) error
+}
+
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport Transport
+	sender    string
+	// templates maps file names, e.g. "user_welcome.tmpl" or the French
+	// "user_welcome.fr.tmpl", to the parsed template.
+	templates map[string]*template.Template
+}
+
+// New returns a Mailer delivering through transport. Every template is
+// parsed here, once, so that a broken template is reported before it's
+// needed. overrides holds *.tmpl files replacing the built-in templates of
+// the same name or adding new ones, e.g. further languages; it may be nil.
+func New(transport Transport, sender string, overrides fs.FS) (Mailer, error) {
+	m := Mailer{
+		transport: transport,
+		sender:    sender,
+		templates: make(map[string]*template.Template),
+	}
+
+	builtin, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		return Mailer{}, err
+	}
+
+	for _, fsys := range []fs.FS{builtin, overrides} {
+		if fsys == nil {
+			continue
+		}
+
+		names, err := fs.Glob(fsys, "*.tmpl")
+		if err != nil {
+			return Mailer{}, err
+		}
+
+		for _, name := range names {
+			tmpl, err := template.New("email").ParseFS(fsys, name)
+			if err != nil {
+				return Mailer{}, err
+			}
+			m.templates[name] = tmpl
+		}
+	}
+
+	return m, nil
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
+// Templates returns the names of the templates without a language, which
+// are the ones callers ask for.
+func (m Mailer) Templates() []string {
+	var names []string
+	for name := range m.templates {
+		if strings.Count(name, ".") == 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup finds the variant of a template for a language tag, trying the
+// whole tag, e.g. "user_welcome.pt-br.tmpl", then shorter prefixes of it,
+// e.g. "user_welcome.pt.tmpl", before the default "user_welcome.tmpl".
+func (m Mailer) lookup(templateFile, language string) (*template.Template, error) {
+	base := strings.TrimSuffix(templateFile, ".tmpl")
+
+	for language != "" {
+		if tmpl, ok := m.templates[base+"."+language+".tmpl"]; ok {
+			return tmpl, nil
+		}
 
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		i := strings.LastIndex(language, "-")
+		if i < 0 {
+			break
+		}
+		language = language[:i]
 	}
+
+	tmpl, ok := m.templates[templateFile]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTemplate, templateFile)
+	}
+
+	return tmpl, nil
 }
 
-// Send sends an email template to a user, "recipient".
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// Render executes the variant of a template for a language, returning the
+// message it makes without a recipient.
+func (m Mailer) Render(templateFile, language string, data interface{}) (Message, error) {
+	tmpl, err := m.lookup(templateFile, strings.ToLower(language))
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		if err == nil {
-			return nil
-		}
+	return Message{
+		From:      m.sender,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
+
+// Send sends an email template to a user, "recipient", in their preferred
+// language when there is a variant of the template for it. It makes a
+// single attempt; callers that need delivery to survive failures send
+// through the job queue, which retries with backoff.
+func (m Mailer) Send(ctx context.Context, recipient, templateFile, language string, data interface{}) (err error) {
+	ctx, span := tracing.StartKind(ctx, "mailer.Send", tracing.KindClient)
+	span.SetAttribute("mail.template", templateFile)
+	span.SetAttribute("mail.language", language)
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
 
-		time.Sleep(500 * time.Millisecond)
+	msg, err := m.Render(templateFile, language, data)
+	if err != nil {
+		return err
 	}
+	msg.To = recipient
 
-	return err
+	return m.transport.Send(ctx, msg)
 }
@@ -0,0 +1,193 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// goMailMessage builds the MIME message for msg, with a plain text body
+// and an HTML alternative.
+func goMailMessage(msg Message) *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+	return m
+}
+
+// SMTPTransport delivers messages to an SMTP server.
+type SMTPTransport struct {
+	dialer *mail.Dialer
+}
+
+// NewSMTPTransport returns a transport sending through the SMTP server at
+// host:port.
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTPTransport{dialer: dialer}
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return t.dialer.DialAndSend(goMailMessage(msg))
+}
+
+// FileTransport writes each message to its own .eml file in a directory,
+// where it can be opened with a mail client. It's meant for local
+// development.
+type FileTransport struct {
+	dir string
+}
+
+// NewFileTransport returns a transport writing to dir, creating the
+// directory if needed.
+func NewFileTransport(dir string) (*FileTransport, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileTransport{dir: dir}, nil
+}
+
+func (t *FileTransport) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Write to a temporary name and rename, so that a half-written message
+	// never shows up as an .eml file.
+	f, err := os.CreateTemp(t.dir, ".mail-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = goMailMessage(msg).WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), filepath.Base(f.Name())[len(".mail-"):])
+	return os.Rename(f.Name(), filepath.Join(t.dir, name))
+}
+
+// Recorder keeps the messages sent through it in memory instead of
+// delivering them, so that tests can inspect what was sent.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (t *Recorder) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (t *Recorder) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Message(nil), t.messages...)
+}
+
+// Reset forgets the messages sent so far.
+func (t *Recorder) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
+
+// HTTPTransport delivers messages by POSTing them as JSON to an email
+// provider's HTTP API, authenticated with a bearer API key. The body is
+//
+//	{"from": "...", "to": "...", "subject": "...", "text": "...", "html": "..."}
+//
+// and any 2xx response counts as accepted.
+type HTTPTransport struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewHTTPTransport returns a transport posting to url.
+func NewHTTPTransport(url, apiKey string) *HTTPTransport {
+	return &HTTPTransport{
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type httpMessage struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(httpMessage{
+		From:    msg.From,
+		To:      msg.To,
+		Subject: msg.Subject,
+		Text:    msg.PlainBody,
+		HTML:    msg.HTMLBody,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("mailer: email API responded %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+// Package migrate applies numbered SQL migrations to PostgreSQL. It keeps
+// the schema version in the same schema_migrations table as the migrate
+// CLI, so databases migrated by either can be managed by the other.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrDirty          = errors.New("migrate: database is dirty after a failed migration; repair it and force a version with the migrate CLI")
+	ErrUnknownVersion = errors.New("migrate: no migration with that version")
+	ErrIrreversible   = errors.New("migrate: migration has no down file")
+)
+
+// lockKey identifies the advisory lock held while migrating, so that
+// instances starting together don't apply the same migration twice.
+const lockKey int64 = 0x6c6967687465 // "lighte"
+
+var filenameRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one schema change with the SQL to apply and revert it.
+type Migration struct {
+	Version uint
+	Name    string
+	up      string
+	down    string
+}
+
+// Step records one migration applied or reverted.
+type Step struct {
+	Version uint
+	Name    string
+	Up      bool
+}
+
+// Status describes the schema version of a database.
+type Status struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+	Latest  uint `json:"latest"`
+}
+
+// Migrator applies the migrations read from a file system to a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New reads the migrations in the root of fsys, named like
+// 000001_create_movies_table.up.sql and 000001_create_movies_table.down.sql.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+
+	for _, entry := range entries {
+		match := filenameRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("migrate: invalid version in %s", entry.Name())
+		}
+
+		src, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[uint(version)] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d has more than one name", version)
+		}
+
+		if match[3] == "up" {
+			m.up = string(src)
+		} else {
+			m.down = string(src)
+		}
+	}
+
+	migrator := &Migrator{db: db}
+
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migrate: version %d has no up file", m.Version)
+		}
+		migrator.migrations = append(migrator.migrations, *m)
+	}
+
+	sort.Slice(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].Version < migrator.migrations[j].Version
+	})
+
+	return migrator, nil
+}
+
+// Migrations returns every known migration, oldest first.
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration{}, m.migrations...)
+}
+
+// Latest returns the version of the newest migration, which is the schema
+// version the code expects.
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// querier is satisfied by both *sql.DB and *sql.Conn.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func version(ctx context.Context, q querier) (uint, bool, error) {
+	_, err := q.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL PRIMARY KEY,
+		dirty boolean NOT NULL
+	)`)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var (
+		v     int64
+		dirty bool
+	)
+
+	err = q.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return uint(v), dirty, nil
+}
+
+// Status returns the database's schema version alongside the latest one.
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	v, dirty, err := version(ctx, m.db)
+	if err != nil {
+		return Status{}, err
+	}
+
+	return Status{Version: v, Dirty: dirty, Latest: m.Latest()}, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	return m.migrate(ctx, func(uint) (uint, error) {
+		return m.Latest(), nil
+	})
+}
+
+// Down reverts the most recently applied migration.
+func (m *Migrator) Down(ctx context.Context) ([]Step, error) {
+	return m.migrate(ctx, func(current uint) (uint, error) {
+		var previous uint
+		for _, migration := range m.migrations {
+			if migration.Version >= current {
+				break
+			}
+			previous = migration.Version
+		}
+		return previous, nil
+	})
+}
+
+// Goto applies or reverts migrations until the schema is at target, where
+// 0 reverts every migration.
+func (m *Migrator) Goto(ctx context.Context, target uint) ([]Step, error) {
+	return m.migrate(ctx, func(uint) (uint, error) {
+		if target != 0 && m.index(target) < 0 {
+			return 0, ErrUnknownVersion
+		}
+		return target, nil
+	})
+}
+
+func (m *Migrator) index(version uint) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// migrate holds the advisory lock while it moves the schema from its
+// current version to the one chosen by target. Each migration runs in its
+// own transaction along with the version update, so a failure leaves the
+// schema at the last migration that succeeded.
+func (m *Migrator) migrate(ctx context.Context, target func(current uint) (uint, error)) ([]Step, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	// Read the version only once the lock is held, as another instance
+	// may have just migrated.
+	current, dirty, err := version(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		return nil, ErrDirty
+	}
+	if current != 0 && m.index(current) < 0 {
+		return nil, fmt.Errorf("migrate: database version %d is not a known migration", current)
+	}
+
+	to, err := target(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []Step
+
+	for _, migration := range m.migrations {
+		if migration.Version <= current || migration.Version > to {
+			continue
+		}
+		if err := apply(ctx, conn, migration.up, migration.Version); err != nil {
+			return steps, fmt.Errorf("migrate: %d_%s up: %w", migration.Version, migration.Name, err)
+		}
+		steps = append(steps, Step{Version: migration.Version, Name: migration.Name, Up: true})
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version > current || migration.Version <= to {
+			continue
+		}
+		if migration.down == "" {
+			return steps, fmt.Errorf("migrate: %d_%s: %w", migration.Version, migration.Name, ErrIrreversible)
+		}
+
+		var previous uint
+		if i > 0 {
+			previous = m.migrations[i-1].Version
+		}
+		if err := apply(ctx, conn, migration.down, previous); err != nil {
+			return steps, fmt.Errorf("migrate: %d_%s down: %w", migration.Version, migration.Name, err)
+		}
+		steps = append(steps, Step{Version: migration.Version, Name: migration.Name, Up: false})
+	}
+
+	return steps, nil
+}
+
+func apply(ctx context.Context, conn *sql.Conn, stmt string, newVersion uint) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if newVersion != 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, int64(newVersion))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,371 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeDB stands in for PostgreSQL: it keeps the schema_migrations row,
+// honours transactions, and records every other statement it runs, which
+// are the migrations themselves.
+type fakeDB struct {
+	mu      sync.Mutex
+	version int64
+	dirty   bool
+	hasRow  bool
+	ran     []string
+	failOn  string
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	db *fakeDB
+	tx *fakeTx
+}
+
+type fakeTx struct {
+	conn    *fakeConn
+	version int64
+	hasRow  bool
+	ran     []string
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.tx = &fakeTx{conn: c, version: c.db.version, hasRow: c.db.hasRow}
+	return c.tx, nil
+}
+
+func (tx *fakeTx) Commit() error {
+	db := tx.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.version, db.hasRow = tx.version, tx.hasRow
+	db.ran = append(db.ran, tx.ran...)
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "pg_advisory"), strings.Contains(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+	case c.tx == nil:
+		return nil, errors.New("migration run outside a transaction")
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations"):
+		c.tx.hasRow = false
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		c.tx.version, c.tx.hasRow = args[0].Value.(int64), true
+	case query == c.db.failOn:
+		return nil, errors.New("syntax error")
+	default:
+		c.tx.ran = append(c.tx.ran, query)
+	}
+
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	rows := &fakeRows{}
+	if c.db.hasRow {
+		rows.values = [][]driver.Value{{c.db.version, c.db.dirty}}
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"version", "dirty"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// testMigrations has a gap at version 3, as real histories do once a
+// migration is dropped before release.
+var testMigrations = fstest.MapFS{
+	"000001_create_a.up.sql":   {Data: []byte("up 1")},
+	"000001_create_a.down.sql": {Data: []byte("down 1")},
+	"000002_create_b.up.sql":   {Data: []byte("up 2")},
+	"000002_create_b.down.sql": {Data: []byte("down 2")},
+	"000004_create_d.up.sql":   {Data: []byte("up 4")},
+	"000004_create_d.down.sql": {Data: []byte("down 4")},
+	"README.md":                {Data: []byte("not a migration")},
+}
+
+func TestMigrator(t *testing.T) {
+	tests := []struct {
+		name        string
+		fsys        fstest.MapFS
+		from        int64
+		dirty       bool
+		failOn      string
+		run         func(*Migrator, context.Context) ([]Step, error)
+		wantRan     []string
+		wantSteps   []Step
+		wantVersion int64
+		wantErr     error
+	}{
+		{
+			name:        "up from empty",
+			run:         (*Migrator).Up,
+			wantRan:     []string{"up 1", "up 2", "up 4"},
+			wantSteps:   []Step{{1, "create_a", true}, {2, "create_b", true}, {4, "create_d", true}},
+			wantVersion: 4,
+		},
+		{
+			name:        "up from partway",
+			from:        2,
+			run:         (*Migrator).Up,
+			wantRan:     []string{"up 4"},
+			wantSteps:   []Step{{4, "create_d", true}},
+			wantVersion: 4,
+		},
+		{
+			name:        "up at latest",
+			from:        4,
+			run:         (*Migrator).Up,
+			wantVersion: 4,
+		},
+		{
+			name:        "down across a gap",
+			from:        4,
+			run:         (*Migrator).Down,
+			wantRan:     []string{"down 4"},
+			wantSteps:   []Step{{4, "create_d", false}},
+			wantVersion: 2,
+		},
+		{
+			name:        "down to empty",
+			from:        1,
+			run:         (*Migrator).Down,
+			wantRan:     []string{"down 1"},
+			wantSteps:   []Step{{1, "create_a", false}},
+			wantVersion: 0,
+		},
+		{
+			name:        "down when empty",
+			run:         (*Migrator).Down,
+			wantVersion: 0,
+		},
+		{
+			name:        "goto forward",
+			run:         gotoVersion(2),
+			wantRan:     []string{"up 1", "up 2"},
+			wantSteps:   []Step{{1, "create_a", true}, {2, "create_b", true}},
+			wantVersion: 2,
+		},
+		{
+			name:        "goto backward reverts newest first",
+			from:        4,
+			run:         gotoVersion(1),
+			wantRan:     []string{"down 4", "down 2"},
+			wantSteps:   []Step{{4, "create_d", false}, {2, "create_b", false}},
+			wantVersion: 1,
+		},
+		{
+			name:        "goto zero",
+			from:        4,
+			run:         gotoVersion(0),
+			wantRan:     []string{"down 4", "down 2", "down 1"},
+			wantSteps:   []Step{{4, "create_d", false}, {2, "create_b", false}, {1, "create_a", false}},
+			wantVersion: 0,
+		},
+		{
+			name:        "goto current",
+			from:        2,
+			run:         gotoVersion(2),
+			wantVersion: 2,
+		},
+		{
+			name:        "goto unknown version",
+			run:         gotoVersion(3),
+			wantVersion: 0,
+			wantErr:     ErrUnknownVersion,
+		},
+		{
+			name:        "dirty",
+			from:        2,
+			dirty:       true,
+			run:         (*Migrator).Up,
+			wantVersion: 2,
+			wantErr:     ErrDirty,
+		},
+		{
+			name:        "failure keeps earlier migrations",
+			failOn:      "up 2",
+			run:         (*Migrator).Up,
+			wantRan:     []string{"up 1"},
+			wantSteps:   []Step{{1, "create_a", true}},
+			wantVersion: 1,
+		},
+		{
+			name: "irreversible",
+			fsys: fstest.MapFS{
+				"000001_create_a.up.sql":   {Data: []byte("up 1")},
+				"000001_create_a.down.sql": {Data: []byte("down 1")},
+				"000002_create_b.up.sql":   {Data: []byte("up 2")},
+			},
+			from:        2,
+			run:         gotoVersion(0),
+			wantVersion: 2,
+			wantErr:     ErrIrreversible,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fdb := &fakeDB{version: tt.from, dirty: tt.dirty, hasRow: tt.from != 0, failOn: tt.failOn}
+			db := sql.OpenDB(fdb)
+			defer db.Close()
+
+			fsys := tt.fsys
+			if fsys == nil {
+				fsys = testMigrations
+			}
+
+			m, err := New(db, fsys)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+
+			steps, err := tt.run(m, context.Background())
+
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			case tt.wantErr == nil && tt.failOn == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.failOn != "" && err == nil:
+				t.Errorf("got no error; want the failure of %q", tt.failOn)
+			}
+
+			if !reflect.DeepEqual(fdb.ran, tt.wantRan) {
+				t.Errorf("ran %q; want %q", fdb.ran, tt.wantRan)
+			}
+			if !reflect.DeepEqual(steps, tt.wantSteps) {
+				t.Errorf("got steps %v; want %v", steps, tt.wantSteps)
+			}
+
+			version := fdb.version
+			if !fdb.hasRow {
+				version = 0
+			}
+			if version != tt.wantVersion {
+				t.Errorf("ended at version %d; want %d", version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func gotoVersion(target uint) func(*Migrator, context.Context) ([]Step, error) {
+	return func(m *Migrator, ctx context.Context) ([]Step, error) {
+		return m.Goto(ctx, target)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name       string
+		fsys       fstest.MapFS
+		wantLatest uint
+		wantErr    string
+	}{
+		{
+			name:       "sorted by version",
+			fsys:       testMigrations,
+			wantLatest: 4,
+		},
+		{
+			name:       "empty",
+			fsys:       fstest.MapFS{},
+			wantLatest: 0,
+		},
+		{
+			name: "down without up",
+			fsys: fstest.MapFS{
+				"000001_create_a.down.sql": {Data: []byte("down 1")},
+			},
+			wantErr: "migrate: version 1 has no up file",
+		},
+		{
+			name: "two names for one version",
+			fsys: fstest.MapFS{
+				"000001_create_a.up.sql": {Data: []byte("up 1")},
+				"000001_create_b.up.sql": {Data: []byte("up 1")},
+			},
+			wantErr: "migrate: version 1 has more than one name",
+		},
+		{
+			name: "version zero",
+			fsys: fstest.MapFS{
+				"000000_create_a.up.sql": {Data: []byte("up 0")},
+			},
+			wantErr: "migrate: invalid version in 000000_create_a.up.sql",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(nil, tt.fsys)
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := m.Latest(); got != tt.wantLatest {
+				t.Errorf("got latest %d; want %d", got, tt.wantLatest)
+			}
+
+			migrations := m.Migrations()
+			for i := 1; i < len(migrations); i++ {
+				if migrations[i-1].Version >= migrations[i].Version {
+					t.Errorf("migrations out of order: %d before %d", migrations[i-1].Version, migrations[i].Version)
+				}
+			}
+		})
+	}
+}
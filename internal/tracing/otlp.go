@@ -0,0 +1,283 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxQueueSize   = 2048
+	maxBatchSize   = 512
+	exportInterval = 5 * time.Second
+)
+
+// Tracer samples new traces and exports finished spans in batches to an
+// OTLP/HTTP collector, using the JSON encoding of the OTLP protocol.
+type Tracer struct {
+	service     string
+	endpoint    string
+	sampleRatio float64
+	client      *http.Client
+
+	queue   chan *Span
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped sync.Once
+
+	// OnError is called with export failures. Spans in a failed batch
+	// are dropped rather than retried.
+	OnError func(error)
+}
+
+// NewTracer returns a Tracer that exports to the collector at endpoint,
+// e.g. "http://localhost:4318", under the given service name. Traces
+// started here are sampled with probability sampleRatio; traces continued
+// from a traceparent header keep the caller's decision.
+func NewTracer(service, endpoint string, sampleRatio float64) *Tracer {
+	t := &Tracer{
+		service:     service,
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		sampleRatio: sampleRatio,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *Span, maxQueueSize),
+		flush:       make(chan chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	go t.run()
+
+	return t
+}
+
+func (t *Tracer) sample() bool {
+	return t.sampleRatio >= 1 || rand.Float64() < t.sampleRatio
+}
+
+// enqueue hands a finished span to the export loop, dropping it when the
+// queue is full so that tracing never blocks a request.
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+
+	export := func() {
+		if len(batch) > 0 {
+			t.export(batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= maxBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			for drained := false; !drained; {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+			export()
+			close(flushed)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Shutdown exports the spans still queued and stops the export loop.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	var err error
+
+	t.stopped.Do(func() {
+		flushed := make(chan struct{})
+
+		select {
+		case t.flush <- flushed:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		close(t.done)
+	})
+
+	return err
+}
+
+func (t *Tracer) export(batch []*Span) {
+	body, err := json.Marshal(t.encode(batch))
+	if err != nil {
+		t.reportError(err)
+		return
+	}
+
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.reportError(err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		t.reportError(fmt.Errorf("tracing: collector responded %s", resp.Status))
+	}
+}
+
+func (t *Tracer) reportError(err error) {
+	if t.OnError != nil {
+		t.OnError(err)
+	}
+}
+
+// The types below follow the OTLP/JSON encoding of an
+// ExportTraceServiceRequest.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOTLPValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		return otlpValue{IntValue: &s}
+	case int32:
+		s := strconv.FormatInt(int64(v), 10)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func (t *Tracer) encode(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+
+	for _, span := range batch {
+		span.mu.Lock()
+
+		encoded := otlpSpan{
+			TraceID:           span.sc.TraceID.String(),
+			SpanID:            span.sc.SpanID.String(),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		}
+		if span.parent.IsValid() {
+			encoded.ParentSpanID = span.parent.String()
+		}
+		for key, value := range span.attributes {
+			encoded.Attributes = append(encoded.Attributes, otlpAttribute{Key: key, Value: newOTLPValue(value)})
+		}
+		if span.err != nil {
+			// 2 is STATUS_CODE_ERROR.
+			encoded.Status = &otlpStatus{Code: 2, Message: span.err.Error()}
+		}
+
+		span.mu.Unlock()
+
+		spans = append(spans, encoded)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{{Key: "service.name", Value: newOTLPValue(t.service)}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/lighten/internal/tracing"},
+				Spans: spans,
+			}},
+		}},
+	}
+}
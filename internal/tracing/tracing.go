@@ -0,0 +1,296 @@
+// Package tracing records spans in the OpenTelemetry model, propagates
+// trace context through W3C traceparent headers and exports finished
+// spans to an OTLP/HTTP collector.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent header")
+
+// TraceID identifies a trace across every service it touches.
+type TraceID [16]byte
+
+// IsValid reports whether t is not the all-zero ID, which W3C reserves.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a single span within a trace.
+type SpanID [8]byte
+
+// IsValid reports whether s is not the all-zero ID, which W3C reserves.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats sc as a version 00 W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value. Versions after
+// 00 are accepted as long as they start with the version 00 fields.
+func ParseTraceparent(header string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return sc, ErrInvalidTraceparent
+	}
+
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.DecodeString(version); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+
+	if len(traceID) != 32 || strings.ToLower(traceID) != traceID {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(traceID)); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+
+	if len(spanID) != 16 || strings.ToLower(spanID) != spanID {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(spanID)); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+
+	var flagByte [1]byte
+	if len(flags) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(flagByte[:], []byte(flags)); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	sc.Sampled = flagByte[0]&1 == 1
+
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+
+	return sc, nil
+}
+
+// SpanKind says how a span relates to the work around it, as in OTLP.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+	KindConsumer SpanKind = 5
+)
+
+// Span is a timed operation within a trace. A nil *Span is valid and
+// records nothing, so callers never need to check for one.
+type Span struct {
+	tracer *Tracer
+
+	mu         sync.Mutex
+	name       string
+	kind       SpanKind
+	sc         SpanContext
+	parent     SpanID
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+// SpanContext returns the IDs identifying the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName replaces the span's name, e.g. once the route is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.name = name
+}
+
+// SetAttribute records a string, bool, integer or float attribute.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// RecordError marks the span as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+// End finishes the span and hands it to the tracer for export. Calls
+// after the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.tracer != nil && s.sc.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanContextKey struct{}
+type remoteContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span as the parent of
+// spans started from it.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns a copy of ctx whose next span continues
+// the trace described by sc, typically parsed from a traceparent header.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the context of the current span, or of
+// the remote parent when no span has been started yet.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.sc
+	}
+	sc, _ := ctx.Value(remoteContextKey{}).(SpanContext)
+	return sc
+}
+
+// Detach returns a context that keeps the trace of ctx but none of its
+// deadline or cancellation, for work that outlives a request.
+func Detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	if span := SpanFromContext(ctx); span != nil {
+		return ContextWithSpan(detached, span)
+	}
+	if sc, ok := ctx.Value(remoteContextKey{}).(SpanContext); ok {
+		return ContextWithRemoteParent(detached, sc)
+	}
+	return detached
+}
+
+var (
+	globalMu     sync.RWMutex
+	globalTracer *Tracer
+)
+
+// SetTracer installs the tracer used by Start. Until one is installed,
+// spans still carry IDs for propagation but are never exported.
+func SetTracer(t *Tracer) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+
+	globalTracer = t
+}
+
+// Start begins an internal span as a child of the span or remote parent
+// in ctx, starting a new trace if there is neither.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal)
+}
+
+// StartKind is Start with an explicit span kind.
+func StartKind(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	globalMu.RLock()
+	tracer := globalTracer
+	globalMu.RUnlock()
+
+	span := &Span{
+		tracer: tracer,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		span.sc.TraceID = parent.TraceID
+		span.sc.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.sc.TraceID[:])
+		span.sc.Sampled = tracer != nil && tracer.sample()
+	}
+	rand.Read(span.sc.SpanID[:])
+
+	return ContextWithSpan(ctx, span), span
+}
@@ -0,0 +1,85 @@
+package tracing
+
+import "testing"
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	want := SpanContext{
+		TraceID: TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	}
+	sampled := want
+	sampled.Sampled = true
+
+	tests := []struct {
+		name    string
+		header  string
+		want    SpanContext
+		wantErr bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", sampled, false},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", want, false},
+		{"other flags ignored", "00-" + traceID + "-" + spanID + "-03", sampled, false},
+		{"surrounding whitespace", "  00-" + traceID + "-" + spanID + "-01 ", sampled, false},
+		{"future version", "cc-" + traceID + "-" + spanID + "-01", sampled, false},
+		{"future version with extra fields", "cc-" + traceID + "-" + spanID + "-01-what-ever", sampled, false},
+		{"empty", "", SpanContext{}, true},
+		{"too few fields", "00-" + traceID + "-" + spanID, SpanContext{}, true},
+		{"version 00 with extra fields", "00-" + traceID + "-" + spanID + "-01-extra", SpanContext{}, true},
+		{"forbidden version", "ff-" + traceID + "-" + spanID + "-01", SpanContext{}, true},
+		{"non-hex version", "zz-" + traceID + "-" + spanID + "-01", SpanContext{}, true},
+		{"long version", "000-" + traceID + "-" + spanID + "-01", SpanContext{}, true},
+		{"short trace id", "00-" + traceID[:30] + "-" + spanID + "-01", SpanContext{}, true},
+		{"uppercase trace id", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", SpanContext{}, true},
+		{"non-hex trace id", "00-" + traceID[:31] + "g-" + spanID + "-01", SpanContext{}, true},
+		{"zero trace id", "00-00000000000000000000000000000000-" + spanID + "-01", SpanContext{}, true},
+		{"short span id", "00-" + traceID + "-" + spanID[:14] + "-01", SpanContext{}, true},
+		{"uppercase span id", "00-" + traceID + "-00F067AA0BA902B7-01", SpanContext{}, true},
+		{"zero span id", "00-" + traceID + "-0000000000000000-01", SpanContext{}, true},
+		{"short flags", "00-" + traceID + "-" + spanID + "-1", SpanContext{}, true},
+		{"non-hex flags", "00-" + traceID + "-" + spanID + "-0x", SpanContext{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTraceparent(tt.header)
+
+			if tt.wantErr {
+				if err != ErrInvalidTraceparent {
+					t.Errorf("got %+v, %v; want ErrInvalidTraceparent", got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	tests := []SpanContext{
+		{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true},
+		{TraceID: TraceID{15: 0xff}, SpanID: SpanID{7: 0xff}, Sampled: false},
+	}
+
+	for _, sc := range tests {
+		header := sc.Traceparent()
+
+		got, err := ParseTraceparent(header)
+		if err != nil {
+			t.Fatalf("ParseTraceparent(%q): %v", header, err)
+		}
+		if got != sc {
+			t.Errorf("ParseTraceparent(%q) = %+v; want %+v", header, got, sc)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	// LanguageRX matches lowercase BCP 47 language tags such as "en" or "pt-br".
+	LanguageRX = regexp.MustCompile("^[a-z]{2,3}(?:-[a-z0-9]{2,8})*$")
 )
 
 // Custom type for validation
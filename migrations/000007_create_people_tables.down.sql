@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code IN ('people:read', 'people:write');
+
+DROP TABLE IF EXISTS movie_credits;
+DROP TABLE IF EXISTS people;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS people (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  name text NOT NULL,
+  birth_year integer NOT NULL DEFAULT 0,
+  biography text NOT NULL DEFAULT '',
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+CREATE TABLE IF NOT EXISTS movie_credits (
+  id bigserial PRIMARY KEY,
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+  role text NOT NULL,
+  character text NOT NULL DEFAULT '',
+  UNIQUE (movie_id, person_id, role, character)
+);
+
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
+
+INSERT INTO permissions (code) VALUES ('people:read'), ('people:write');
+
+-- Anyone who could read or write movies gets the matching access to people.
+INSERT INTO users_permissions
+SELECT users_permissions.user_id, people_permissions.id
+FROM users_permissions
+INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+INNER JOIN permissions people_permissions
+ON people_permissions.code = replace(permissions.code, 'movies:', 'people:')
+WHERE permissions.code IN ('movies:read', 'movies:write');
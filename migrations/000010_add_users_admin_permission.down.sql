@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS permissions_code_idx;
+
+DELETE FROM permissions WHERE code = 'users:admin';
@@ -0,0 +1,4 @@
+INSERT INTO permissions (code) VALUES ('users:admin');
+
+-- Permission codes must be unique for grants by code to be unambiguous.
+CREATE UNIQUE INDEX IF NOT EXISTS permissions_code_idx ON permissions (code);
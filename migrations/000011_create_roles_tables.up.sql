@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS roles (
+  id bigserial PRIMARY KEY,
+  name text NOT NULL UNIQUE,
+  description text NOT NULL DEFAULT '',
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+  role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+  PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+  PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS users_roles_role_id_idx ON users_roles (role_id);
+
+INSERT INTO roles (name, description) VALUES
+('viewer', 'Read access to movies and people'),
+('editor', 'Read and write access to movies and people'),
+('admin', 'Full access, including user administration');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles
+INNER JOIN permissions ON
+(roles.name = 'viewer' AND permissions.code IN ('movies:read', 'people:read')) OR
+(roles.name = 'editor' AND permissions.code IN ('movies:read', 'movies:write', 'people:read', 'people:write')) OR
+(roles.name = 'admin');
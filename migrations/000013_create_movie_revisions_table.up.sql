@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  version integer NOT NULL,
+  action text NOT NULL,
+  changed_fields text[] NOT NULL,
+  user_id bigint REFERENCES users ON DELETE SET NULL,
+  reverted_from integer,
+  snapshot jsonb NOT NULL,
+  UNIQUE (movie_id, version)
+);
+
+-- Record the current state of existing movies as their first revision, so
+-- every movie has a history to revert to.
+INSERT INTO movie_revisions (created_at, movie_id, version, action, changed_fields, snapshot)
+SELECT created_at, id, version, 'create', '{title,year,runtime,genres}',
+  jsonb_build_object(
+    'title', title,
+    'year', year,
+    'runtime', runtime || ' mins',
+    'genres', to_jsonb(genres),
+    'deleted', deleted_at IS NOT NULL
+  )
+FROM movies;
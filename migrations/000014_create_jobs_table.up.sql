@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS jobs (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  kind text NOT NULL,
+  payload jsonb NOT NULL,
+  state text NOT NULL DEFAULT 'pending',
+  attempts integer NOT NULL DEFAULT 0,
+  max_attempts integer NOT NULL,
+  run_at timestamp with time zone NOT NULL DEFAULT NOW(),
+  locked_until timestamp with time zone,
+  last_error text NOT NULL DEFAULT ''
+);
+
+ALTER TABLE jobs ADD CONSTRAINT jobs_state_check CHECK (state IN ('pending', 'running', 'dead'));
+
+-- Workers look for pending jobs that are due, and running jobs whose
+-- worker's lease has run out.
+CREATE INDEX IF NOT EXISTS jobs_pending_idx ON jobs (run_at) WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs (locked_until) WHERE state = 'running';
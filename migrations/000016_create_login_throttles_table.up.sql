@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+  user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+  failures integer NOT NULL DEFAULT 0,
+  retry_after timestamp with time zone,
+  locked_until timestamp with time zone,
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS login_failures (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  user_id bigint REFERENCES users ON DELETE SET NULL,
+  email citext NOT NULL,
+  ip_address text NOT NULL,
+  reason text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_email_idx ON login_failures (email);
+CREATE INDEX IF NOT EXISTS login_failures_user_id_idx ON login_failures (user_id);
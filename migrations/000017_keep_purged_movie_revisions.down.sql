@@ -0,0 +1,3 @@
+DELETE FROM movie_revisions WHERE movie_id NOT IN (SELECT id FROM movies);
+ALTER TABLE movie_revisions ADD CONSTRAINT movie_revisions_movie_id_fkey
+  FOREIGN KEY (movie_id) REFERENCES movies ON DELETE CASCADE;
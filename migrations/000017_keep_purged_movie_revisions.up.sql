@@ -0,0 +1,3 @@
+-- Keep the history of purged movies: revisions outlive the movie row, and
+-- Purge records a final "purge" revision for each movie it removes.
+ALTER TABLE movie_revisions DROP CONSTRAINT IF EXISTS movie_revisions_movie_id_fkey;
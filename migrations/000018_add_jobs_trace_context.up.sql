@@ -0,0 +1,3 @@
+-- The trace and request that enqueued a job, so its attempts continue them.
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS traceparent text NOT NULL DEFAULT '';
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS request_id text NOT NULL DEFAULT '';
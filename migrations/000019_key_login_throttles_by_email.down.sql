@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS login_throttles;
+
+CREATE TABLE IF NOT EXISTS login_throttles (
+  user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+  failures integer NOT NULL DEFAULT 0,
+  retry_after timestamp with time zone,
+  locked_until timestamp with time zone,
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,12 @@
+-- Throttle failed logins by the email tried rather than by user, so that
+-- guesses at unknown emails are throttled too, and can't be told apart
+-- from guesses at accounts that exist. Existing throttles are dropped.
+DROP TABLE IF EXISTS login_throttles;
+
+CREATE TABLE IF NOT EXISTS login_throttles (
+  email citext PRIMARY KEY,
+  failures integer NOT NULL DEFAULT 0,
+  retry_after timestamp with time zone,
+  locked_until timestamp with time zone,
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);